@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"strings"
 
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
@@ -31,6 +30,10 @@ type wrapper interface {
 	Unwrap() error
 }
 
+type multiWrapper interface {
+	Unwrap() []error
+}
+
 // Is reports whether any error in err's chain matches target.
 //
 // The chain consists of err itself followed by the sequence of errors obtained by
@@ -70,11 +73,20 @@ func Find(err error, matcher func(err error) bool) error {
 // walking at this point. If `processor` returns an `error` stop walking from there
 // and bubble up the error through `Walk` return value.
 //
+// An error wrapping multiple errors (through `Unwrap() []error`, like [MultiError]) has each of
+// them walked in order, depth first, until `processor` stops the walk.
+//
 // Returns an `error` if `processor` returned an `error`, `nil` otherwise
 func Walk(err error, processor func(err error) (bool, error)) error {
+	_, walkErr := walk(err, processor)
+	return walkErr
+}
+
+// walk is [Walk] also returning whether `processor` stopped the walk.
+func walk(err error, processor func(err error) (bool, error)) (bool, error) {
 	shouldContinue, childErr := processor(err)
 	if !shouldContinue {
-		return childErr
+		return true, childErr
 	}
 
 	for err != nil {
@@ -83,21 +95,33 @@ func Walk(err error, processor func(err error) (bool, error)) error {
 			err = v.Cause()
 		case wrapper:
 			err = v.Unwrap()
+		case multiWrapper:
+			for _, member := range v.Unwrap() {
+				if member == nil {
+					continue
+				}
+
+				if stopped, childErr := walk(member, processor); stopped {
+					return true, childErr
+				}
+			}
+
+			return false, nil
 		default:
-			return nil
+			return false, nil
 		}
 
 		if err == nil {
-			return nil
+			return false, nil
 		}
 
 		shouldContinue, childErr := processor(err)
 		if !shouldContinue {
-			return childErr
+			return true, childErr
 		}
 	}
 
-	return nil
+	return false, nil
 }
 
 // FindFirstMatching walks the error(s) stack (causes chain) and return the first
@@ -121,18 +145,32 @@ func HasAny(err error, cause error) bool {
 //
 // - If `err` is already an `ErrorResponse`, turns it into such and returns it.
 // - If `err` was wrapped, find the most cause which is an `ErrorResponse` and returns it.
-// - If `err` is a status.Status (or one that was wrapped), convert it to an ErrorResponse
+// - If `err` is a status.Status (or one that was wrapped), convert it to an ErrorResponse, see `FromGRPCStatus`
+// - If `err` is a `CircuitOpenError` (or one that was wrapped), returns a `503` ErrorResponse
+// - If `err` is a `MultiError` (or one that was wrapped) found before any `ErrorResponse`, returns
+// the ErrorResponse of its member having the most severe (highest) HTTP status
 // - Otherwise, return an `UnexpectedError` with the cause sets to `err` received.
 func ToErrorResponse(ctx context.Context, err error) *ErrorResponse {
-	response := Find(err, isErrorResponse)
+	response := Find(err, func(candidateErr error) bool { return isErrorResponse(candidateErr) || isMultiError(candidateErr) })
+	if multi, ok := response.(*MultiError); ok {
+		return multi.errorResponse(ctx)
+	}
+
 	if response != nil {
 		return response.(*ErrorResponse)
 	}
 
 	response = Find(err, isStatusCode)
 	if response != nil {
-		status, _ := status.FromError(err)
-		return convertStatusToErrorResponse(ctx, status)
+		status, _ := status.FromError(response)
+		if errResponse := FromGRPCStatus(ctx, status); errResponse != nil {
+			return errResponse
+		}
+	}
+
+	response = Find(err, isCircuitOpenError)
+	if response != nil {
+		return CircuitOpenErrorResponse(ctx, response.(*CircuitOpenError))
 	}
 
 	return UnexpectedError(ctx, err)
@@ -154,19 +192,6 @@ func isErrorResponse(err error) bool {
 	return false
 }
 
-func convertStatusToErrorResponse(ctx context.Context, st *status.Status) *ErrorResponse {
-	switch st.Code() {
-	case codes.InvalidArgument:
-		return HTTPBadRequestError(ctx, nil, ErrorCode("request_validation_error"), st.Message())
-	case codes.Unavailable:
-		return HTTPServiceUnavailableError(ctx, nil, ErrorCode("service_unavailable_error"), "Service Unavailable")
-	case codes.NotFound:
-		return HTTPNotFoundError(ctx, nil, ErrorCode("not_found_error"), st.Message())
-	default:
-		return UnexpectedError(ctx, st.Err())
-	}
-}
-
 // DebugErrorChain returns a debug human friendly string represents the full stack of errors
 // with the type of.
 func DebugErrorChain(err error) (out string) {
@@ -0,0 +1,351 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a [CircuitBreaker].
+type CircuitState int
+
+const (
+	// CircuitClosed lets all the calls through, counting the failures.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects all the calls with a [CircuitOpenError] until the cool down elapsed.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a limited number of trial calls through, closing the circuit if they
+	// all succeed and opening it again on the first failure.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "invalid"
+	}
+}
+
+// CircuitOpenError is returned by [CircuitBreaker.Execute] when the call was rejected because
+// the circuit is open. It's turned into a `503` by [ToErrorResponse] and into `codes.Unavailable`
+// by [ToGRPCStatus].
+type CircuitOpenError struct {
+	// Name is the name of the circuit breaker that rejected the call
+	Name string
+
+	// RetryIn is the remaining cool down before the circuit breaker lets trial calls through, or
+	// the full cool down when the call was rejected because the trial calls are still in flight
+	RetryIn time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %q is open, retry in %s", e.Name, e.RetryIn.Round(time.Millisecond))
+}
+
+// RetryDelay returns the remaining cool down of the circuit breaker, see [RetryDelayHint].
+func (e *CircuitOpenError) RetryDelay() time.Duration {
+	return e.RetryIn
+}
+
+func isCircuitOpenError(err error) bool {
+	_, ok := err.(*CircuitOpenError)
+	return ok
+}
+
+// CircuitBreakerOption configures a [CircuitBreaker] created through [NewCircuitBreaker].
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// CircuitConsecutiveFailures opens the circuit after `count` consecutive failures. This is
+// the default trip condition, with a `count` of 5.
+func CircuitConsecutiveFailures(count uint64) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.shouldTrip = func(counts circuitCounts) bool {
+			return counts.consecutiveFailures >= count
+		}
+	}
+}
+
+// CircuitFailureRatio opens the circuit when the ratio of failed calls over all the calls
+// reaches `ratio`, once at least `minCalls` calls were made. The calls are counted since the
+// circuit closed, or since the start of the current interval, see [CircuitInterval].
+func CircuitFailureRatio(ratio float64, minCalls uint64) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.shouldTrip = func(counts circuitCounts) bool {
+			return counts.calls >= minCalls && float64(counts.failures)/float64(counts.calls) >= ratio
+		}
+	}
+}
+
+// CircuitInterval resets the calls counters every `interval` while the circuit is closed, by
+// default they are only reset when the circuit changes state.
+func CircuitInterval(interval time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.interval = interval
+	}
+}
+
+// CircuitCoolDown is the time the circuit stays open before letting trial calls through,
+// 10s by default.
+func CircuitCoolDown(coolDown time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.coolDown = coolDown
+	}
+}
+
+// CircuitHalfOpenCalls is the number of trial calls let through while half-open, all of them
+// must succeed for the circuit to close, 1 by default.
+func CircuitHalfOpenCalls(count uint64) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.halfOpenCalls = count
+	}
+}
+
+// CircuitClassifiedBy configures which errors count as failures, the other errors counting as
+// successes. By default, every error counts as a failure except `context.Canceled` and the errors
+// known to be permanent (see [IsTransient]), like a [FatalError] or a `4xx`, which signal a problem
+// with the call itself rather than with the callee.
+func CircuitClassifiedBy(classifier RetryClassifier) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.classifier = classifier
+	}
+}
+
+// CircuitClock makes the circuit breaker measure time using `clock`, which is [RealClock] by default.
+func CircuitClock(clock Clock) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.clock = clock
+	}
+}
+
+// CircuitOnStateChange registers `hook` to be called each time the circuit changes state.
+func CircuitOnStateChange(hook func(name string, from CircuitState, to CircuitState)) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.onStateChange = hook
+	}
+}
+
+// CircuitBreaker stops calling a failing dependency for a while, rejecting the calls right away
+// with a [CircuitOpenError] instead, so that retrying callers do not multiply the load on it
+// during an outage. It's safe for concurrent use and is meant to be shared by all the callers
+// of the same dependency.
+//
+// Use it directly through [CircuitBreaker.Execute] or as a [RetryContext] policy through the
+// [RetryCircuitBreaker] option.
+type CircuitBreaker struct {
+	name          string
+	shouldTrip    func(counts circuitCounts) bool
+	interval      time.Duration
+	coolDown      time.Duration
+	halfOpenCalls uint64
+	classifier    RetryClassifier
+	onStateChange func(name string, from CircuitState, to CircuitState)
+	clock         Clock
+
+	lock       sync.Mutex
+	state      CircuitState
+	generation uint64
+	counts     circuitCounts
+	expiry     time.Time
+}
+
+type circuitCounts struct {
+	calls                uint64
+	failures             uint64
+	successes            uint64
+	consecutiveFailures  uint64
+	consecutiveSuccesses uint64
+}
+
+func (c *circuitCounts) onSuccess() {
+	c.successes++
+	c.consecutiveSuccesses++
+	c.consecutiveFailures = 0
+}
+
+func (c *circuitCounts) onFailure() {
+	c.failures++
+	c.consecutiveFailures++
+	c.consecutiveSuccesses = 0
+}
+
+// NewCircuitBreaker creates a new closed [CircuitBreaker], `name` identifying it in errors
+// and logs.
+func NewCircuitBreaker(name string, opts ...CircuitBreakerOption) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		name:          name,
+		coolDown:      10 * time.Second,
+		halfOpenCalls: 1,
+		classifier:    RetryClassifierFunc(isCircuitFailure),
+		clock:         RealClock,
+	}
+
+	CircuitConsecutiveFailures(5)(cb)
+	for _, opt := range opts {
+		opt(cb)
+	}
+
+	cb.toNewGeneration(cb.clock.Now())
+	return cb
+}
+
+func isCircuitFailure(err error) bool {
+	return TransientClassifier.IsRetryable(err) && !Is(err, context.Canceled)
+}
+
+// Name returns the name of the circuit breaker.
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State returns the current state of the circuit breaker.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	state, _ := cb.currentState(cb.clock.Now())
+	return state
+}
+
+// Execute calls `f` if the circuit breaker lets the call through and records its outcome,
+// otherwise returns a [CircuitOpenError] right away without calling `f`. A panic of `f`, or a
+// `runtime.Goexit` like `t.FailNow` does, is recorded as a failure before being propagated.
+func (cb *CircuitBreaker) Execute(ctx context.Context, f func(ctx context.Context) error) (err error) {
+	generation, err := cb.beforeCall()
+	if err != nil {
+		return err
+	}
+
+	completed := false
+	defer func() {
+		if !completed {
+			// Either a panic or `runtime.Goexit`, nothing is recovered in the latter case and
+			// the goroutine must keep exiting
+			recovered := recover()
+			cb.afterCall(generation, false)
+			if recovered != nil {
+				panic(recovered)
+			}
+		}
+	}()
+
+	err = f(ctx)
+	completed = true
+	cb.afterCall(generation, err == nil || !cb.classifier.IsRetryable(err))
+
+	return err
+}
+
+func (cb *CircuitBreaker) beforeCall() (uint64, error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	now := cb.clock.Now()
+	state, generation := cb.currentState(now)
+
+	if state == CircuitOpen {
+		return generation, &CircuitOpenError{Name: cb.name, RetryIn: cb.expiry.Sub(now)}
+	}
+
+	if state == CircuitHalfOpen && cb.counts.calls >= cb.halfOpenCalls {
+		// The trial calls are still in flight, if one of them fails the circuit opens again for a full cool down
+		return generation, &CircuitOpenError{Name: cb.name, RetryIn: cb.coolDown}
+	}
+
+	cb.counts.calls++
+	return generation, nil
+}
+
+func (cb *CircuitBreaker) afterCall(before uint64, success bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	now := cb.clock.Now()
+	state, generation := cb.currentState(now)
+	if generation != before {
+		// The outcome of a call started before the last state change is irrelevant
+		return
+	}
+
+	if success {
+		cb.counts.onSuccess()
+		if state == CircuitHalfOpen && cb.counts.consecutiveSuccesses >= cb.halfOpenCalls {
+			cb.setState(CircuitClosed, now)
+		}
+
+		return
+	}
+
+	cb.counts.onFailure()
+	if state == CircuitHalfOpen || cb.shouldTrip(cb.counts) {
+		cb.setState(CircuitOpen, now)
+	}
+}
+
+func (cb *CircuitBreaker) currentState(now time.Time) (CircuitState, uint64) {
+	switch cb.state {
+	case CircuitClosed:
+		if !cb.expiry.IsZero() && cb.expiry.Before(now) {
+			cb.toNewGeneration(now)
+		}
+	case CircuitOpen:
+		if cb.expiry.Before(now) {
+			cb.setState(CircuitHalfOpen, now)
+		}
+	}
+
+	return cb.state, cb.generation
+}
+
+func (cb *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	if cb.state == state {
+		return
+	}
+
+	previous := cb.state
+	cb.state = state
+	cb.toNewGeneration(now)
+
+	if cb.onStateChange != nil {
+		cb.onStateChange(cb.name, previous, state)
+	}
+}
+
+func (cb *CircuitBreaker) toNewGeneration(now time.Time) {
+	cb.generation++
+	cb.counts = circuitCounts{}
+
+	switch cb.state {
+	case CircuitClosed:
+		cb.expiry = time.Time{}
+		if cb.interval > 0 {
+			cb.expiry = now.Add(cb.interval)
+		}
+	case CircuitOpen:
+		cb.expiry = now.Add(cb.coolDown)
+	default:
+		cb.expiry = time.Time{}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	var transitions []string
+	cb := NewCircuitBreaker("test", CircuitConsecutiveFailures(2), CircuitCoolDown(20*time.Millisecond), CircuitOnStateChange(func(name string, from, to CircuitState) {
+		transitions = append(transitions, fmt.Sprintf("%s -> %s", from, to))
+	}))
+
+	failing := func(ctx context.Context) error { return errors.New("failed") }
+	succeeding := func(ctx context.Context) error { return nil }
+
+	assert.EqualError(t, cb.Execute(context.Background(), failing), "failed")
+	assert.NoError(t, cb.Execute(context.Background(), succeeding))
+	assert.EqualError(t, cb.Execute(context.Background(), failing), "failed")
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	assert.EqualError(t, cb.Execute(context.Background(), failing), "failed")
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	called := false
+	err := cb.Execute(context.Background(), func(ctx context.Context) error { called = true; return nil })
+	assert.False(t, called)
+
+	var circuitOpen *CircuitOpenError
+	require.True(t, errors.As(err, &circuitOpen))
+	assert.Equal(t, "test", circuitOpen.Name)
+	assert.True(t, circuitOpen.RetryIn > 0 && circuitOpen.RetryIn <= 20*time.Millisecond)
+
+	time.Sleep(25 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	assert.EqualError(t, cb.Execute(context.Background(), failing), "failed")
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	time.Sleep(25 * time.Millisecond)
+	assert.NoError(t, cb.Execute(context.Background(), succeeding))
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	assert.Equal(t, []string{
+		"closed -> open",
+		"open -> half-open",
+		"half-open -> open",
+		"open -> half-open",
+		"half-open -> closed",
+	}, transitions)
+}
+
+func TestCircuitBreaker_FailureRatio(t *testing.T) {
+	cb := NewCircuitBreaker("test", CircuitFailureRatio(0.5, 4))
+
+	failing := func(ctx context.Context) error { return errors.New("failed") }
+	succeeding := func(ctx context.Context) error { return nil }
+
+	cb.Execute(context.Background(), failing)
+	cb.Execute(context.Background(), failing)
+	cb.Execute(context.Background(), succeeding)
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	cb.Execute(context.Background(), succeeding)
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	cb.Execute(context.Background(), failing)
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreaker_Classifier(t *testing.T) {
+	cb := NewCircuitBreaker("test", CircuitConsecutiveFailures(1))
+
+	cb.Execute(context.Background(), func(ctx context.Context) error { return NewFatalError(errors.New("bad input")) })
+	cb.Execute(context.Background(), func(ctx context.Context) error { return context.Canceled })
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	cb = NewCircuitBreaker("test", CircuitConsecutiveFailures(1), CircuitClassifiedBy(StrictRetryClassifier))
+	cb.Execute(context.Background(), func(ctx context.Context) error { return errors.New("permanent") })
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	cb.Execute(context.Background(), func(ctx context.Context) error { return NewRetryableError(errors.New("transient")) })
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreaker_RetryPolicy(t *testing.T) {
+	cb := NewCircuitBreaker("test", CircuitConsecutiveFailures(2), CircuitCoolDown(time.Hour))
+
+	var count int
+	err := RetryContext(context.Background(), 10, func(ctx context.Context) error {
+		count++
+		return errors.New("failed")
+	}, RetryBackoff(BackoffConstant, time.Millisecond, 0), RetryCircuitBreaker(cb))
+
+	assert.Equal(t, 2, count)
+
+	var circuitOpen *CircuitOpenError
+	assert.True(t, errors.As(err, &circuitOpen))
+
+	response := ToErrorResponse(context.Background(), Wrap(err, "calling"))
+	assert.Equal(t, 503, response.Status)
+	assert.Equal(t, C("circuit_open_error"), response.Code)
+	assert.True(t, response.RetryDelay > 59*time.Minute)
+
+	assert.Equal(t, codes.Unavailable, ToGRPCStatus(context.Background(), err).Code())
+}
+
+func TestCircuitBreaker_HalfOpenPanic(t *testing.T) {
+	cb := NewCircuitBreaker("test", CircuitConsecutiveFailures(1), CircuitCoolDown(20*time.Millisecond))
+
+	assert.Error(t, cb.Execute(context.Background(), func(ctx context.Context) error { return errors.New("failed") }))
+	time.Sleep(25 * time.Millisecond)
+	require.Equal(t, CircuitHalfOpen, cb.State())
+
+	assert.PanicsWithValue(t, "boom", func() {
+		cb.Execute(context.Background(), func(ctx context.Context) error { panic("boom") })
+	})
+	assert.Equal(t, CircuitOpen, cb.State(), "a panicking trial call should count as a failure")
+
+	time.Sleep(25 * time.Millisecond)
+	assert.NoError(t, cb.Execute(context.Background(), func(ctx context.Context) error { return nil }))
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestCircuitBreaker_Goexit(t *testing.T) {
+	cb := NewCircuitBreaker("test", CircuitConsecutiveFailures(1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cb.Execute(context.Background(), func(ctx context.Context) error { runtime.Goexit(); return nil })
+	}()
+	<-done
+
+	assert.Equal(t, CircuitOpen, cb.State(), "a call exiting its goroutine should count as a failure")
+}
+
+func TestCircuitBreaker_HalfOpenRejectionHint(t *testing.T) {
+	cb := NewCircuitBreaker("test", CircuitConsecutiveFailures(1), CircuitCoolDown(20*time.Millisecond))
+
+	assert.Error(t, cb.Execute(context.Background(), func(ctx context.Context) error { return errors.New("failed") }))
+	time.Sleep(25 * time.Millisecond)
+
+	started := make(chan struct{})
+	trial := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cb.Execute(context.Background(), func(ctx context.Context) error { close(started); <-trial; return nil })
+	}()
+	<-started
+
+	err := cb.Execute(context.Background(), func(ctx context.Context) error { return nil })
+
+	var circuitOpen *CircuitOpenError
+	require.True(t, errors.As(err, &circuitOpen))
+	assert.Equal(t, 20*time.Millisecond, circuitOpen.RetryIn)
+
+	close(trial)
+	<-done
+}
@@ -15,17 +15,226 @@
 package derr
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
 
 	"go.uber.org/zap"
+	"golang.org/x/term"
 )
 
+// CheckFormat is how [Check] reports the error before exiting, see [SetCheckFormat].
+type CheckFormat int
+
+const (
+	// CheckFormatAuto renders the error as text when the standard error is a terminal and logs
+	// it otherwise
+	CheckFormatAuto CheckFormat = iota
+
+	// CheckFormatLog logs the error through the `derr` logger
+	CheckFormatLog
+
+	// CheckFormatText renders the error for humans on the standard error, see [RenderError]
+	CheckFormatText
+
+	// CheckFormatJSON renders the error as a JSON object on the standard error, see [RenderError]
+	CheckFormatJSON
+)
+
+var checkFormat = CheckFormatAuto
+
+// checkOutput is replaced in tests
+var checkOutput io.Writer = os.Stderr
+
+// SetCheckFormat configures how [Check] reports the error, [CheckFormatAuto] by default. Command
+// line tools typically call it with [CheckFormatJSON] when invoked with `--output json`.
+func SetCheckFormat(format CheckFormat) {
+	checkFormat = format
+}
+
+// Check reports `err` and exits the process through [Exit] if `err` is not `nil`, the exit code being
+// determined by [ExitCode], `1` unless the error specifies otherwise (see [ExitError]). The error is
+// logged or rendered for humans depending on the [CheckFormat], see [SetCheckFormat].
+//
+// Only the `derr` logger and the ones registered with [RegisterExitLogger] are flushed before
+// exiting, not all the loggers obtained via `streamingfast/logging`, its registry offering no
+// safe way to enumerate them: register the loggers whose last entries matter.
 func Check(prefix string, err error) {
 	if err != nil {
-		zlog.Error(prefix, zap.Error(err))
-		zlog.Sync()
-		os.Exit(1)
+		format := checkFormat
+		if format == CheckFormatAuto {
+			format = CheckFormatLog
+			if isTerminal(os.Stderr) {
+				format = CheckFormatText
+			}
+		}
+
+		if format == CheckFormatLog {
+			zlog.Error(prefix, zap.Error(err))
+		} else {
+			RenderError(checkOutput, format, prefix, err)
+		}
+
+		Exit(ExitCode(err))
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
+
+// WithHint attaches `hint`, an advice telling the user how to fix the problem, to `err`, see
+// [Hints]. The message of the returned error is the one of `err`. Returns `nil` if `err` is `nil`.
+func WithHint(err error, hint string) error {
+	if err == nil {
+		return nil
 	}
+
+	return &hintError{original: err, hint: hint}
+}
+
+type hintError struct {
+	original error
+	hint     string
+}
+
+func (e *hintError) Unwrap() error {
+	return e.original
+}
+
+func (e *hintError) Error() string {
+	return e.original.Error()
+}
+
+// Hints walks the error(s) stack (causes chain) and returns all the hints attached to it
+// through [WithHint], outermost first.
+func Hints(err error) (hints []string) {
+	Walk(err, func(candidateErr error) (bool, error) {
+		if v, ok := candidateErr.(*hintError); ok {
+			hints = append(hints, v.hint)
+		}
+
+		return true, nil
+	})
+
+	return hints
+}
+
+// RenderError writes `err` to `w` for the end user of a command line tool, using [CheckFormatText]
+// or [CheckFormatJSON] `format`. The rendering shows `prefix`, the causes chain of `err` without
+// the repetitions of the wrapped messages, the code and details of the first [ErrorResponse] found
+// in it and its hints (see [WithHint]).
+func RenderError(w io.Writer, format CheckFormat, prefix string, err error) error {
+	causes := errorCauses(err)
+
+	var code ErrorCode
+	var details map[string]interface{}
+	if response := Find(err, isErrorResponse); response != nil {
+		code = response.(*ErrorResponse).Code
+		details = response.(*ErrorResponse).Details
+	}
+
+	if format == CheckFormatJSON {
+		out := map[string]interface{}{
+			"error":     prefix,
+			"causes":    causes,
+			"hints":     Hints(err),
+			"exit_code": ExitCode(err),
+		}
+
+		if code != "" {
+			out["code"] = code
+			out["details"] = details
+		}
+
+		return json.NewEncoder(w).Encode(out)
+	}
+
+	out := &strings.Builder{}
+	message := prefix
+	if len(causes) > 0 {
+		if message != "" {
+			message += ": "
+		}
+
+		message += causes[0]
+		causes = causes[1:]
+	}
+
+	fmt.Fprintf(out, "Error: %s\n", message)
+	for _, cause := range causes {
+		fmt.Fprintf(out, "  caused by: %s\n", cause)
+	}
+
+	if code != "" {
+		fmt.Fprintf(out, "Code: %s\n", code)
+	}
+
+	if len(details) > 0 {
+		keys := make([]string, 0, len(details))
+		for key := range details {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(out, "Details:\n")
+		for _, key := range keys {
+			fmt.Fprintf(out, "  %s: %v\n", key, details[key])
+		}
+	}
+
+	for _, hint := range Hints(err) {
+		fmt.Fprintf(out, "Hint: %s\n", hint)
+	}
+
+	_, writeErr := io.WriteString(w, out.String())
+	return writeErr
+}
+
+// errorCauses returns the message specific to each error of the causes chain, that is without
+// the message of the wrapped error it usually ends with.
+func errorCauses(err error) (causes []string) {
+	var chain []error
+	Walk(err, func(candidateErr error) (bool, error) {
+		if candidateErr != nil {
+			chain = append(chain, candidateErr)
+		}
+
+		return true, nil
+	})
+
+	for i, candidate := range chain {
+		message := candidate.Error()
+		switch candidate := candidate.(type) {
+		case *ErrorResponse:
+			message = candidate.Message
+		case *RetryableError:
+			// It only flags the wrapped error as retryable, it has no message of its own
+			continue
+		default:
+			if i+1 < len(chain) {
+				next := chain[i+1].Error()
+				if message == next {
+					// A transparent wrapper, like the ones of `WithHint` or `NewExitError`, the
+					// wrapped error tells it all
+					continue
+				}
+
+				message = strings.TrimSuffix(message, ": "+next)
+			}
+		}
+
+		if message == "" || (len(causes) > 0 && causes[len(causes)-1] == message) {
+			continue
+		}
+
+		causes = append(causes, message)
+	}
+
+	return causes
 }
 
 // Deprecated: use `Check` with `derr.Check`).
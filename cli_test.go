@@ -0,0 +1,96 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCLIError() error {
+	ctx := context.Background()
+
+	response := HTTPBadRequestError(ctx, errors.New("unknown field \"port\""), C("invalid_config_error"), "The configuration is invalid.", "file", "config.yaml")
+	err := WithHint(Wrap(Wrap(response, "reading config.yaml"), "loading config"), "run `app config validate` to see all the problems")
+
+	return NewExitError(ExitConfig, NewRetryableError(err))
+}
+
+func TestRenderError_Text(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	require.NoError(t, RenderError(buffer, CheckFormatText, "unable to start", testCLIError()))
+
+	assert.Equal(t, `Error: unable to start: loading config
+  caused by: reading config.yaml
+  caused by: The configuration is invalid.
+  caused by: unknown field "port"
+Code: invalid_config_error
+Details:
+  file: config.yaml
+Hint: run `+"`app config validate`"+` to see all the problems
+`, buffer.String())
+}
+
+func TestRenderError_JSON(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	require.NoError(t, RenderError(buffer, CheckFormatJSON, "unable to start", testCLIError()))
+
+	assert.JSONEq(t, `{
+		"error": "unable to start",
+		"causes": ["loading config", "reading config.yaml", "The configuration is invalid.", "unknown field \"port\""],
+		"code": "invalid_config_error",
+		"details": {"file": "config.yaml"},
+		"hints": ["run `+"`app config validate`"+` to see all the problems"],
+		"exit_code": 78
+	}`, buffer.String())
+}
+
+func TestRenderError_Deduplication(t *testing.T) {
+	err := fmt.Errorf("c: %w", fmt.Errorf("b: %w", NewFatalError(errors.New("a"))))
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, RenderError(buffer, CheckFormatText, "", err))
+	assert.Equal(t, "Error: c\n  caused by: b\n  caused by: a\n", buffer.String())
+}
+
+func TestRenderError_DecoratedCause(t *testing.T) {
+	err := fmt.Errorf("loading config: %w (while upgrading)", errors.New("file not found"))
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, RenderError(buffer, CheckFormatText, "", err))
+	assert.Equal(t, "Error: loading config: file not found (while upgrading)\n  caused by: file not found\n", buffer.String())
+}
+
+func TestCheck_Format(t *testing.T) {
+	osExit = func(code int) {}
+	buffer := &bytes.Buffer{}
+	checkOutput = buffer
+	defer func() {
+		osExit = os.Exit
+		checkOutput = os.Stderr
+		SetCheckFormat(CheckFormatAuto)
+	}()
+
+	SetCheckFormat(CheckFormatText)
+	Check("failed", errors.New("test"))
+	assert.Equal(t, "Error: failed: test\n", buffer.String())
+}
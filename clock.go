@@ -0,0 +1,76 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time used by the retry loop, the circuit breaker, the retry budget, the
+// hedged calls, the shutdown hooks and the signal handler. It exists so tests can control time,
+// see `derrtest.FakeClock`.
+type Clock interface {
+	Now() time.Time
+
+	// NewTimer creates a new [Timer] that will send the current time on its channel after at
+	// least duration `d`.
+	NewTimer(d time.Duration) Timer
+
+	// AfterFunc waits for the duration to elapse and then calls `f` in its own goroutine. The
+	// returned [Timer] can be used to cancel the call using its `Stop` method, its channel is `nil`.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the equivalent of a `*time.Timer` created through a [Clock].
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// RealClock is the [Clock] backed by the `time` package, used by default.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return realTimer{time.AfterFunc(d, f)} }
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.timer.C }
+func (t realTimer) Stop() bool          { return t.timer.Stop() }
+
+// withClockTimeout is `context.WithTimeout` measuring `timeout` with `clock`. Unless `clock` is
+// [RealClock], the context reports no deadline and its `Err` is `context.Canceled` once `timeout`
+// elapsed, its cause (see `context.Cause`) being `context.DeadlineExceeded` in both cases.
+func withClockTimeout(ctx context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if clock == RealClock {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := clock.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+
+	return ctx, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The external tests use `derrtest.FakeClock`, which can only be imported from the external test
+// package, `derrtest` importing `derr` itself.
+package derr_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/derr"
+	"github.com/streamingfast/derr/derrtest"
+)
+
+// runWithFakeClock calls `run` with a [derrtest.FakeClock], advancing it each time `run` waits
+// on it, until `run` returns. Returns the error of `run` and the fake time that elapsed.
+func runWithFakeClock(t *testing.T, run func(clock derr.Clock) error) (error, time.Duration) {
+	t.Helper()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := derrtest.NewFakeClock(start)
+
+	done := make(chan error, 1)
+	go func() { done <- run(clock) }()
+
+	for {
+		select {
+		case err := <-done:
+			return err, clock.Now().Sub(start)
+		default:
+		}
+
+		if clock.WaitForTimers(1, 10*time.Millisecond) {
+			clock.Advance(time.Second)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package derrtest provides utilities to test code relying on `derr`, like a controllable
+// [derr.Clock] making retries and graceful shutdowns deterministic.
+package derrtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/derr"
+)
+
+// FakeClock is a [derr.Clock] whose time only moves forward when [FakeClock.Advance] is called,
+// firing the timers that expired in the process. It's safe for concurrent use.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+var _ derr.Clock = (*FakeClock)(nil)
+
+// NewFakeClock creates a new [FakeClock] whose current time is `now`.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) derr.Timer {
+	return c.addTimer(d, make(chan time.Time, 1), nil)
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) derr.Timer {
+	return c.addTimer(d, nil, f)
+}
+
+// Advance moves the current time forward by `d`, firing in order all the timers expiring
+// up to the new current time. Functions registered with `AfterFunc` are called in their own
+// goroutine, like `time.AfterFunc` does.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].deadline.Before(c.timers[j].deadline) })
+
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+
+		timer.fire(c.now)
+	}
+
+	c.timers = pending
+	c.notifyChanged()
+}
+
+// Timers returns the number of timers currently waiting to fire.
+func (c *FakeClock) Timers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.timers)
+}
+
+// WaitForTimers blocks until at least `count` timers are waiting to fire, which is how a test
+// knows the code under test reached the point where it waits, typically before calling
+// [FakeClock.Advance]. Returns `false` if it did not happen within `timeout` (real time).
+func (c *FakeClock) WaitForTimers(count int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+
+	for {
+		c.lock.Lock()
+		if len(c.timers) >= count {
+			c.lock.Unlock()
+			return true
+		}
+		changed := c.changed
+		c.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func (c *FakeClock) addTimer(d time.Duration, ch chan time.Time, f func()) *fakeTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	timer := &fakeTimer{clock: c, deadline: c.now.Add(d), ch: ch, f: f}
+	if d <= 0 {
+		timer.fire(c.now)
+		return timer
+	}
+
+	c.timers = append(c.timers, timer)
+	c.notifyChanged()
+
+	return timer
+}
+
+func (c *FakeClock) removeTimer(timer *fakeTimer) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, candidate := range c.timers {
+		if candidate == timer {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.notifyChanged()
+			return true
+		}
+	}
+
+	return false
+}
+
+// notifyChanged must be called with the lock held
+func (c *FakeClock) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	ch       chan time.Time
+	f        func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.clock.removeTimer(t)
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		go t.f()
+		return
+	}
+
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derrtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/derr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	timer := clock.NewTimer(time.Second)
+	fired := make(chan struct{})
+	clock.AfterFunc(2*time.Second, func() { close(fired) })
+	stopped := clock.NewTimer(time.Second)
+	assert.Equal(t, 3, clock.Timers())
+
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(500*time.Millisecond), clock.Now())
+	select {
+	case <-timer.C():
+		t.Fatal("timer should not have fired yet")
+	default:
+	}
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, start.Add(time.Second+500*time.Millisecond+time.Second), clock.Now())
+	assert.Equal(t, start.Add(2500*time.Millisecond), <-timer.C())
+	<-fired
+	assert.Equal(t, 0, clock.Timers())
+}
+
+func TestFakeClock_Retry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	done := make(chan error)
+	go func() {
+		done <- derr.RetryContext(context.Background(), 3, func(ctx context.Context) error {
+			return errors.New("failed")
+		}, derr.RetryBackoff(derr.BackoffConstant, time.Hour, 0), derr.RetryClock(clock))
+	}()
+
+	for i := 0; i < 3; i++ {
+		require.True(t, clock.WaitForTimers(1, 5*time.Second))
+		clock.Advance(time.Hour)
+	}
+
+	err := <-done
+	var exhausted *derr.RetriesExhaustedError
+	require.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, uint64(4), exhausted.Attempts)
+	assert.Equal(t, 3*time.Hour, exhausted.Elapsed)
+}
+
+func TestFakeClock_CircuitBreaker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cb := derr.NewCircuitBreaker("test", derr.CircuitConsecutiveFailures(1), derr.CircuitCoolDown(time.Minute), derr.CircuitClock(clock))
+
+	cb.Execute(context.Background(), func(ctx context.Context) error { return errors.New("failed") })
+	assert.Equal(t, derr.CircuitOpen, cb.State())
+
+	clock.Advance(59 * time.Second)
+	assert.Equal(t, derr.CircuitOpen, cb.State())
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, derr.CircuitHalfOpen, cb.State())
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const errorHistorySize = 50
+
+// RecordedError is an error kept in the recent error history, see [RecentErrors].
+type RecordedError struct {
+	At      time.Time
+	Message string
+	Err     error
+}
+
+type errorHistory struct {
+	lock    sync.Mutex
+	entries []RecordedError
+	next    int
+}
+
+var recentErrors = &errorHistory{}
+
+func (h *errorHistory) record(message string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	entry := RecordedError{At: time.Now(), Message: message, Err: err}
+	if len(h.entries) < errorHistorySize {
+		h.entries = append(h.entries, entry)
+		return
+	}
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % errorHistorySize
+}
+
+func (h *errorHistory) snapshot() []RecordedError {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	out := make([]RecordedError, 0, len(h.entries))
+	out = append(out, h.entries[h.next:]...)
+	return append(out, h.entries[:h.next]...)
+}
+
+// RecordError adds `err` to the recent error history included in the diagnostic dumps, see
+// [WriteDiagnostics]. The errors logged at the error level by `derr` itself (server errors
+// written by [WriteError], failed gRPC handlers, recovered panics) are recorded automatically.
+func RecordError(message string, err error) {
+	recentErrors.record(message, err)
+}
+
+// RecentErrors returns the last 50 recorded errors, oldest first, see [RecordError].
+func RecentErrors() []RecordedError {
+	return recentErrors.snapshot()
+}
+
+// WriteDiagnostics writes a human readable diagnostic dump of the process to `w`: the memory
+// statistics, the recent error history and the stacks of all the goroutines.
+func WriteDiagnostics(w io.Writer) error {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "=== Diagnostics of process %d at %s\n\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+
+	fmt.Fprintf(buffer, "=== Memory\n")
+	fmt.Fprintf(buffer, "goroutines: %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(buffer, "heap_alloc: %d\n", stats.HeapAlloc)
+	fmt.Fprintf(buffer, "heap_inuse: %d\n", stats.HeapInuse)
+	fmt.Fprintf(buffer, "heap_sys: %d\n", stats.HeapSys)
+	fmt.Fprintf(buffer, "heap_objects: %d\n", stats.HeapObjects)
+	fmt.Fprintf(buffer, "sys: %d\n", stats.Sys)
+	fmt.Fprintf(buffer, "num_gc: %d\n", stats.NumGC)
+	fmt.Fprintf(buffer, "gc_pause_total: %s\n\n", time.Duration(stats.PauseTotalNs))
+
+	history := RecentErrors()
+	fmt.Fprintf(buffer, "=== Recent errors (%d)\n", len(history))
+	for _, entry := range history {
+		fmt.Fprintf(buffer, "%s %s: %s\n", entry.At.UTC().Format(time.RFC3339Nano), entry.Message, entry.Err)
+	}
+
+	fmt.Fprintf(buffer, "\n=== Goroutines\n")
+	if err := pprof.Lookup("goroutine").WriteTo(buffer, 2); err != nil {
+		return fmt.Errorf("unable to write goroutines: %w", err)
+	}
+
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// dumpDiagnostics writes the diagnostic dump to a new file in `directory`, or to `logger` when
+// `directory` is empty.
+func dumpDiagnostics(logger *zap.Logger, directory string) {
+	buffer := &bytes.Buffer{}
+	if err := WriteDiagnostics(buffer); err != nil {
+		logger.Warn("unable to generate diagnostics", zap.Error(err))
+		return
+	}
+
+	if directory == "" {
+		logger.Info("diagnostics dump", zap.String("diagnostics", buffer.String()))
+		return
+	}
+
+	path := filepath.Join(directory, fmt.Sprintf("diagnostics-%d-%s.txt", os.Getpid(), time.Now().UTC().Format("20060102T150405.000000000")))
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		logger.Warn("unable to write diagnostics", zap.String("path", path), zap.Error(err))
+		return
+	}
+
+	logger.Info("diagnostics dumped", zap.String("path", path))
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorHistory(t *testing.T) {
+	history := &errorHistory{}
+	for i := 0; i < errorHistorySize+5; i++ {
+		history.record("failed", fmt.Errorf("error %d", i))
+	}
+
+	entries := history.snapshot()
+	require.Len(t, entries, errorHistorySize)
+	assert.EqualError(t, entries[0].Err, "error 5")
+	assert.EqualError(t, entries[errorHistorySize-1].Err, fmt.Sprintf("error %d", errorHistorySize+4))
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	RecordError("processing block", errors.New("diagnostics test error"))
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, WriteDiagnostics(buffer))
+
+	dump := buffer.String()
+	assert.Contains(t, dump, "=== Memory\ngoroutines: ")
+	assert.Contains(t, dump, "heap_alloc: ")
+	assert.Contains(t, dump, "processing block: diagnostics test error\n")
+	assert.Contains(t, dump, "=== Goroutines\ngoroutine ")
+	assert.Contains(t, dump, "TestWriteDiagnostics")
+}
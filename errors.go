@@ -48,6 +48,23 @@ func ServiceUnavailableError(ctx context.Context, cause error, serviceName strin
 	return HTTPBadGatewayError(ctx, cause, ErrorCode("service_unavailable"), "The service your are requesting is not currently available.")
 }
 
+// ShuttingDownError represents a request that was rejected because the service is draining
+// its traffic prior to shutting down. Clients are expected to retry against another instance.
+func ShuttingDownError(ctx context.Context) *ErrorResponse {
+	return HTTPServiceUnavailableError(ctx, nil, shuttingDownErrorCode, "The service is shutting down.")
+}
+
+const shuttingDownErrorCode = ErrorCode("shutting_down_error")
+
+// CircuitOpenErrorResponse represents a request that was rejected because the circuit breaker
+// protecting one of its dependencies is open. The `RetryDelay` is the remaining cool down.
+func CircuitOpenErrorResponse(ctx context.Context, cause *CircuitOpenError) *ErrorResponse {
+	response := HTTPServiceUnavailableError(ctx, cause, ErrorCode("circuit_open_error"), "The service is temporarily unavailable.")
+	response.RetryDelay = cause.RetryIn
+
+	return response
+}
+
 func UnexpectedError(ctx context.Context, cause error) *ErrorResponse {
 	return HTTPInternalServerError(ctx, cause, ErrorCode("unexpected_error"), "An unexpected error occurred.")
 }
@@ -155,4 +172,5 @@ var statusToHTTPErrorClass = map[int]errorClass{
 
 func logError(ctx context.Context, message string, err error, fields ...zap.Field) {
 	logging.Logger(ctx, zlog).Error(message, append(fields, zap.Error(err))...)
+	RecordError(message, err)
 }
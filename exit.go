@@ -0,0 +1,184 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The exit codes of the process, following the `sysexits.h` conventions, see [ExitCode].
+const (
+	ExitOK          = 0
+	ExitFailure     = 1
+	ExitUsage       = 64  // The command was used incorrectly (bad arguments or flags)
+	ExitDataErr     = 65  // The input data was incorrect
+	ExitNoInput     = 66  // An input file did not exist or was not readable
+	ExitUnavailable = 69  // A service or a backend is unavailable
+	ExitSoftware    = 70  // An internal software error (a bug)
+	ExitIOErr       = 74  // An error occurred while doing I/O
+	ExitTempFail    = 75  // A temporary failure, the command can be retried later
+	ExitNoPerm      = 77  // Insufficient permissions
+	ExitConfig      = 78  // The configuration is invalid
+	ExitInterrupted = 130 // The process was interrupted (`SIGINT`), by shell convention
+)
+
+// ExitError attaches the exit code of the process to an error, see [Check] and [ExitCode].
+type ExitError struct {
+	Code int
+
+	original error
+}
+
+// NewExitError creates a new [ExitError] struct ensuring `original` error is non-nil
+// otherwise this function panics with an error.
+func NewExitError(code int, original error) *ExitError {
+	if original == nil {
+		panic(fmt.Errorf("the 'original' argument is mandatory"))
+	}
+
+	return &ExitError{Code: code, original: original}
+}
+
+// ExitCode returns the exit code of the process.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.original
+}
+
+func (e *ExitError) Error() string {
+	return e.original.Error()
+}
+
+// ExitCoder is implemented by the errors carrying the exit code of the process, like [ExitError],
+// [ShutdownError] and [SignalError].
+type ExitCoder interface {
+	ExitCode() int
+}
+
+// ExitCode walks the error(s) stack (causes chain) of `err` and returns the exit code given by the
+// first [ExitCoder] found in it, [ExitFailure] if there is none and [ExitOK] if `err` is `nil`.
+//
+// Without any [ExitCoder], a `context.Canceled` error is assumed to result from the termination
+// signal received by the process wide [ShutdownManager] if any, the exit code being the one of
+// the signal (see [SignalError.ExitCode]), [ExitInterrupted] for `SIGINT`. It covers the usual
+// `ctx.Err()` returned by code that doesn't use `context.Cause`.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var coder ExitCoder
+	Walk(err, func(candidateErr error) (bool, error) {
+		if candidate, ok := candidateErr.(ExitCoder); ok {
+			coder = candidate
+			return false, nil
+		}
+
+		return true, nil
+	})
+
+	if coder != nil {
+		return coder.ExitCode()
+	}
+
+	if errors.Is(err, context.Canceled) {
+		if signal := defaultShutdownManager.receivedSignal(); signal != nil {
+			return (&SignalError{Signal: signal}).ExitCode()
+		}
+	}
+
+	return ExitFailure
+}
+
+// ExitHooksTimeout bounds the time all the exit hooks can take, see [RegisterExitHook].
+var ExitHooksTimeout = 5 * time.Second
+
+// osExit is replaced in tests
+var osExit = os.Exit
+
+var exitHooksLock sync.Mutex
+var exitHooks []*shutdownHook
+var exitLoggers []*zap.Logger
+
+// RegisterExitHook registers `hook` to be run by [Exit] right before the process exits, to flush
+// buffered sinks, exporters or remove temporary files that deferred calls would have handled if
+// the process was not exiting abruptly. The hooks run concurrently and are abandoned once
+// [ExitHooksTimeout] elapsed.
+func RegisterExitHook(name string, hook ShutdownHook) {
+	exitHooksLock.Lock()
+	defer exitHooksLock.Unlock()
+
+	exitHooks = append(exitHooks, &shutdownHook{phase: "exit", name: name, hook: hook})
+}
+
+// RegisterExitLogger registers `logger` to be flushed by [Exit] right before the process exits,
+// after the exit hooks ran. The `derr` logger is always flushed, but not the other loggers obtained
+// via `streamingfast/logging`, its registry offering no safe way to enumerate them.
+func RegisterExitLogger(logger *zap.Logger) {
+	exitHooksLock.Lock()
+	defer exitHooksLock.Unlock()
+
+	exitLoggers = append(exitLoggers, logger)
+}
+
+// Exit runs the exit hooks (see [RegisterExitHook]), flushes the `derr` logger and the ones
+// registered with [RegisterExitLogger] and exits the process with `code`, the other loggers
+// obtained via `streamingfast/logging` not being flushed. It's what [Check] and the force kill
+// of the [ShutdownManager] use to exit.
+func Exit(code int) {
+	runExitHooks()
+	syncLoggers()
+
+	osExit(code)
+}
+
+func runExitHooks() {
+	exitHooksLock.Lock()
+	hooks := append([]*shutdownHook(nil), exitHooks...)
+	exitHooksLock.Unlock()
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	registry := NewShutdown(ShutdownPhases("exit"), ShutdownTimeout(ExitHooksTimeout), ShutdownHookTimeout(0))
+	for _, h := range hooks {
+		registry.Register(h.phase, h.name, h.hook)
+	}
+
+	if err := registry.Run(context.Background()); err != nil {
+		zlog.Warn("exit hooks did not complete successfully", zap.Error(err))
+	}
+}
+
+func syncLoggers() {
+	exitHooksLock.Lock()
+	loggers := append([]*zap.Logger{zlog}, exitLoggers...)
+	exitHooksLock.Unlock()
+
+	for _, logger := range loggers {
+		logger.Sync()
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"nil", nil, ExitOK},
+		{"plain", errors.New("test"), ExitFailure},
+		{"exit error", Wrap(NewExitError(ExitConfig, errors.New("invalid config")), "loading"), ExitConfig},
+		{"outermost exit error wins", NewExitError(ExitUsage, NewExitError(ExitConfig, errors.New("test"))), ExitUsage},
+		{"shutdown error", &ShutdownError{TimedOut: true}, 124},
+		{"sigint", &SignalError{Signal: syscall.SIGINT}, ExitInterrupted},
+		{"sigterm", fmt.Errorf("stopped: %w", &SignalError{Signal: syscall.SIGTERM}), 143},
+		{"context canceled without signal", fmt.Errorf("stream: %w", context.Canceled), ExitFailure},
+		{"context deadline", fmt.Errorf("stream: %w", context.DeadlineExceeded), ExitFailure},
+		{"fatal", NewFatalError(Status(codes.Unavailable, "test")), ExitFailure},
+		{"transient", Status(codes.Unavailable, "test"), ExitFailure},
+		{"server error", HTTPServiceUnavailableError(context.Background(), nil, ErrorCode("test_error"), "Unavailable."), ExitFailure},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ExitCode(test.err))
+		})
+	}
+
+	withProcessLifecycle(t, func(lifecycle *Lifecycle) {
+		defaultShutdownManager.handlers.received = syscall.SIGINT
+		assert.Equal(t, ExitInterrupted, ExitCode(fmt.Errorf("stream: %w", context.Canceled)))
+		assert.Equal(t, ExitFailure, ExitCode(fmt.Errorf("stream: %w", context.DeadlineExceeded)))
+		assert.Equal(t, ExitConfig, ExitCode(NewExitError(ExitConfig, context.Canceled)))
+	})
+}
+
+func TestCheck(t *testing.T) {
+	var exitCodes []int
+	osExit = func(code int) { exitCodes = append(exitCodes, code) }
+	defer func() { osExit = os.Exit }()
+
+	Check("no error", nil)
+	Check("config", NewExitError(ExitConfig, errors.New("invalid config")))
+	Check("plain", errors.New("test"))
+
+	assert.Equal(t, []int{ExitConfig, ExitFailure}, exitCodes)
+}
+
+func TestExit_RunsHooks(t *testing.T) {
+	var exitCodes []int
+	osExit = func(code int) { exitCodes = append(exitCodes, code) }
+	defer func() { osExit = os.Exit }()
+
+	previousTimeout := ExitHooksTimeout
+	ExitHooksTimeout = 20 * time.Millisecond
+	defer func() {
+		ExitHooksTimeout = previousTimeout
+		exitHooks = nil
+		exitLoggers = nil
+	}()
+
+	flushed := false
+	RegisterExitHook("sink", func(ctx context.Context) error {
+		flushed = true
+		return nil
+	})
+
+	release := make(chan struct{})
+	defer close(release)
+	RegisterExitHook("stuck", func(ctx context.Context) error { <-release; return nil })
+
+	synced := &syncCountingCore{}
+	RegisterExitLogger(zap.New(synced))
+
+	start := time.Now()
+	Check("failed", NewExitError(ExitUnavailable, errors.New("backend unavailable")))
+
+	assert.True(t, flushed)
+	assert.True(t, time.Since(start) < time.Second, "stuck hook should have been abandoned")
+	assert.Equal(t, []int{ExitUnavailable}, exitCodes)
+	assert.Equal(t, 1, synced.syncs)
+}
+
+type syncCountingCore struct {
+	zapcore.Core
+	syncs int
+}
+
+func (c *syncCountingCore) Enabled(zapcore.Level) bool { return false }
+func (c *syncCountingCore) Sync() error                { c.syncs++; return nil }
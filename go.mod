@@ -1,8 +1,9 @@
 module github.com/streamingfast/derr
 
-go 1.16
+go 1.20
 
 require (
+	github.com/golang/protobuf v1.4.1
 	github.com/lithammer/dedent v1.1.0
 	github.com/pkg/errors v0.8.1
 	github.com/sethvargo/go-retry v0.2.3
@@ -12,8 +13,24 @@ require (
 	go.opencensus.io v0.22.1
 	go.uber.org/atomic v1.7.0
 	go.uber.org/zap v1.21.0
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.27.0
+)
+
+require (
+	github.com/blendle/zapdriver v1.3.1 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/groupcache v0.0.0-20190702054246-869f871628b6 // indirect
+	github.com/logrusorgru/aurora v2.0.3+incompatible // indirect
+	github.com/mitchellh/go-testing-interface v1.14.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292 // indirect
 	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
-	google.golang.org/genproto v0.0.0-20191108220845-16a3f7862a1a
-	google.golang.org/grpc v1.22.1
+	golang.org/x/text v0.3.6 // indirect
+	google.golang.org/protobuf v1.23.1-0.20200526195155-81db48ad09cc // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
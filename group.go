@@ -0,0 +1,182 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// GroupOption configures a [Group] created through [NewGroup].
+type GroupOption func(*Group)
+
+// GroupLimit bounds the number of tasks running concurrently to `limit`, [Group.Go] blocking
+// until a slot is available. The number of tasks is not limited by default.
+func GroupLimit(limit int) GroupOption {
+	return func(g *Group) {
+		if limit > 0 {
+			g.slots = make(chan struct{}, limit)
+		}
+	}
+}
+
+// GroupCollectAll makes the [Group] run all its tasks to completion and report all their errors
+// instead of canceling its context on the first error, which is the default (fail fast) mode. The
+// context is still canceled when a task returns a [FatalError].
+func GroupCollectAll() GroupOption {
+	return func(g *Group) {
+		g.collectAll = true
+	}
+}
+
+// Group runs tasks concurrently and collects their errors, like `errgroup.Group` does but keeping
+// all the errors (see [MultiError]), honoring [FatalError] and turning panics into [PanicError].
+type Group struct {
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	slots      chan struct{}
+	collectAll bool
+
+	wg   sync.WaitGroup
+	lock sync.Mutex
+	errs []error
+}
+
+// NewGroup creates a new [Group] along with its context, derived from `ctx`, which is passed to
+// the tasks. The context is canceled when a task fails in fail fast mode, when a task returns a
+// [FatalError] in collect all mode, or once [Group.Wait] returns.
+func NewGroup(ctx context.Context, opts ...GroupOption) (*Group, context.Context) {
+	g := &Group{}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	g.ctx, g.cancel = context.WithCancelCause(ctx)
+	return g, g.ctx
+}
+
+// Go runs `fn` in a new goroutine, `name` identifying the task in its error, see [GroupTaskError].
+// Blocks while the [GroupLimit] is reached.
+func (g *Group) Go(name string, fn func(ctx context.Context) error) {
+	if g.slots != nil {
+		g.slots <- struct{}{}
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			if g.slots != nil {
+				<-g.slots
+			}
+
+			g.wg.Done()
+		}()
+
+		if err := runRecovered(g.ctx, fn); err != nil {
+			g.fail(&GroupTaskError{Name: name, Err: err})
+		}
+	}()
+}
+
+func (g *Group) fail(err *GroupTaskError) {
+	g.lock.Lock()
+	g.errs = append(g.errs, err)
+	g.lock.Unlock()
+
+	var fatalError *FatalError
+	if !g.collectAll || errors.As(err, &fatalError) {
+		g.cancel(err)
+	}
+}
+
+// Wait blocks until all the tasks completed and returns a [MultiError] with the errors of the
+// failed tasks, in completion order, or `nil` if none failed.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel(context.Canceled)
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if len(g.errs) == 0 {
+		return nil
+	}
+
+	return &MultiError{Errors: append([]error(nil), g.errs...)}
+}
+
+// GroupTaskError is the error of a task run by a [Group].
+type GroupTaskError struct {
+	Name string
+	Err  error
+}
+
+func (e *GroupTaskError) Unwrap() error {
+	return e.Err
+}
+
+func (e *GroupTaskError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Err)
+}
+
+// MultiError is a set of errors, like the ones of the tasks run by a [Group]. Its members are
+// walked by [Walk], and so inspected by [Find] and [Is], and [ToErrorResponse] turns it into the
+// response of its member having the most severe HTTP status.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+func (e *MultiError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d errors: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func isMultiError(err error) bool {
+	_, ok := err.(*MultiError)
+	return ok
+}
+
+// errorResponse returns the [ErrorResponse] of the member having the highest HTTP status, the
+// first one on ties.
+func (e *MultiError) errorResponse(ctx context.Context) *ErrorResponse {
+	var worst *ErrorResponse
+	for _, err := range e.Errors {
+		response := ToErrorResponse(ctx, err)
+		if worst == nil || response.ResponseStatus() > worst.ResponseStatus() {
+			worst = response
+		}
+	}
+
+	if worst == nil {
+		return UnexpectedError(ctx, e)
+	}
+
+	return worst
+}
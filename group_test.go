@@ -0,0 +1,208 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroup_Success(t *testing.T) {
+	group, _ := NewGroup(context.Background())
+
+	var count int32
+	for i := 0; i < 5; i++ {
+		group.Go("task", func(ctx context.Context) error {
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+	}
+
+	assert.NoError(t, group.Wait())
+	assert.Equal(t, int32(5), atomic.LoadInt32(&count))
+}
+
+func TestGroup_Limit(t *testing.T) {
+	group, _ := NewGroup(context.Background(), GroupLimit(2))
+
+	var running, maxRunning int32
+	for i := 0; i < 10; i++ {
+		group.Go("task", func(ctx context.Context) error {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				previous := atomic.LoadInt32(&maxRunning)
+				if current <= previous || atomic.CompareAndSwapInt32(&maxRunning, previous, current) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}
+
+	require.NoError(t, group.Wait())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxRunning))
+}
+
+func TestGroup_FailFast(t *testing.T) {
+	group, ctx := NewGroup(context.Background())
+
+	group.Go("blocked", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	group.Go("failing", func(ctx context.Context) error {
+		return io.ErrUnexpectedEOF
+	})
+
+	err := group.Wait()
+
+	var multiErr *MultiError
+	require.True(t, errors.As(err, &multiErr))
+	assert.Len(t, multiErr.Errors, 2)
+	assert.EqualError(t, multiErr.Errors[0], "failing: unexpected EOF")
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	var taskErr *GroupTaskError
+	require.True(t, errors.As(context.Cause(ctx), &taskErr))
+	assert.Equal(t, "failing", taskErr.Name)
+}
+
+func TestGroup_CollectAll(t *testing.T) {
+	group, ctx := NewGroup(context.Background(), GroupCollectAll())
+
+	group.Go("first", func(ctx context.Context) error {
+		return errors.New("first failed")
+	})
+	group.Go("second", func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		if ctx.Err() != nil {
+			return errors.New("second canceled")
+		}
+
+		return errors.New("second failed")
+	})
+
+	err := group.Wait()
+
+	var multiErr *MultiError
+	require.True(t, errors.As(err, &multiErr))
+	assert.Equal(t, []string{"first: first failed", "second: second failed"}, errorMessages(multiErr.Errors))
+	assert.EqualError(t, err, "2 errors: first: first failed; second: second failed")
+	assert.Error(t, ctx.Err(), "context should be canceled once Wait returns")
+}
+
+func TestGroup_CollectAll_CancelsOnFatal(t *testing.T) {
+	group, ctx := NewGroup(context.Background(), GroupCollectAll())
+
+	group.Go("blocked", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	group.Go("fatal", func(ctx context.Context) error {
+		return NewFatalError(errors.New("corrupted"))
+	})
+
+	err := group.Wait()
+	assert.NotNil(t, Find(err, func(err error) bool { _, ok := err.(*FatalError); return ok }))
+	assert.True(t, Is(err, context.Canceled))
+
+	var fatalErr *FatalError
+	assert.True(t, errors.As(context.Cause(ctx), &fatalErr))
+}
+
+func TestGroup_Panic(t *testing.T) {
+	group, _ := NewGroup(context.Background())
+
+	group.Go("panicking", func(ctx context.Context) error {
+		panic("boom")
+	})
+
+	err := group.Wait()
+
+	var panicErr *PanicError
+	require.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, "boom", panicErr.Value)
+	assert.EqualError(t, err, "panicking: panic: boom")
+}
+
+func TestMultiError_Walk(t *testing.T) {
+	err := Wrap(&MultiError{Errors: []error{
+		&GroupTaskError{Name: "a", Err: io.EOF},
+		&GroupTaskError{Name: "b", Err: io.ErrUnexpectedEOF},
+	}}, "processing")
+
+	assert.True(t, Is(err, io.EOF))
+	assert.True(t, Is(err, io.ErrUnexpectedEOF))
+	assert.False(t, Is(err, io.ErrClosedPipe))
+
+	var visited []string
+	Walk(err, func(err error) (bool, error) {
+		if taskErr, ok := err.(*GroupTaskError); ok {
+			visited = append(visited, taskErr.Name)
+			return taskErr.Name != "a", nil
+		}
+
+		return true, nil
+	})
+	assert.Equal(t, []string{"a"}, visited, "walk should stop as soon as the processor stops")
+}
+
+func TestMultiError_ToErrorResponse(t *testing.T) {
+	ctx := context.Background()
+
+	notFound := HTTPErrorFromStatus(http.StatusNotFound, ctx, nil, ErrorCode("not_found_error"), "not found")
+	unavailable := ServiceUnavailableError(ctx, nil, "storage")
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expected       *ErrorResponse
+	}{
+		{"most severe member", &MultiError{Errors: []error{notFound, unavailable}}, http.StatusBadGateway, unavailable},
+		{"wrapped members", Wrap(&MultiError{Errors: []error{Wrap(unavailable, "a"), Wrap(notFound, "b")}}, "c"), http.StatusBadGateway, unavailable},
+		{"first on ties", &MultiError{Errors: []error{notFound, HTTPErrorFromStatus(http.StatusNotFound, ctx, nil, ErrorCode("other_error"), "other")}}, http.StatusNotFound, notFound},
+		{"unexpected member", &MultiError{Errors: []error{notFound, io.EOF}}, http.StatusInternalServerError, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := ToErrorResponse(ctx, test.err)
+			assert.Equal(t, test.expectedStatus, response.ResponseStatus())
+			if test.expected != nil {
+				assert.Same(t, test.expected, response)
+			}
+		})
+	}
+}
+
+func errorMessages(errs []error) (out []string) {
+	for _, err := range errs {
+		out = append(out, err.Error())
+	}
+
+	return
+}
@@ -0,0 +1,309 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TraceIDMetadataKey is the gRPC metadata key under which the trace ID of a failed call is
+// sent back in the response trailers.
+const TraceIDMetadataKey = "x-trace-id"
+
+// errorInfoTraceIDKey is the `errdetails.ErrorInfo` metadata key holding the trace ID
+// of an `ErrorResponse` converted to a gRPC status.
+const errorInfoTraceIDKey = "trace_id"
+
+// ToGRPCStatus turns a plain `error` interface into a proper gRPC `*status.Status`
+// object. It does so with the following rules:
+//
+//   - If `err` is `nil`, returns `nil`.
+//   - If `err` is or wraps an `ErrorResponse`, converts it to a status whose code is mapped from
+//     the HTTP status, with an `errdetails.ErrorInfo` detail holding the error code, trace ID and details.
+//   - If `err` is or wraps a status.Status, returns it.
+//   - If `err` is or wraps `context.Canceled` or `context.DeadlineExceeded`, returns a status
+//     with respectively `codes.Canceled` or `codes.DeadlineExceeded`.
+//   - If `err` is or wraps a `RetryableError`, returns a status with `codes.Unavailable`.
+//   - If `err` is or wraps a `CircuitOpenError`, returns a status with `codes.Unavailable` and
+//     an `errdetails.RetryInfo` detail holding the remaining cool down.
+//   - Otherwise, returns a status with `codes.Internal` and the error's message.
+func ToGRPCStatus(ctx context.Context, err error) *status.Status {
+	if err == nil {
+		return nil
+	}
+
+	if response := Find(err, isErrorResponse); response != nil {
+		return response.(*ErrorResponse).GRPCStatus()
+	}
+
+	if found := Find(err, isStatusCode); found != nil {
+		st, _ := status.FromError(found)
+		return st
+	}
+
+	switch {
+	case Is(err, context.Canceled):
+		return status.New(codes.Canceled, err.Error())
+	case Is(err, context.DeadlineExceeded):
+		return status.New(codes.DeadlineExceeded, err.Error())
+	case Find(err, isRetryableError) != nil:
+		return status.New(codes.Unavailable, err.Error())
+	case Find(err, isCircuitOpenError) != nil:
+		return ToErrorResponse(ctx, err).GRPCStatus()
+	}
+
+	return status.New(codes.Internal, err.Error())
+}
+
+func convertErrorResponseToStatus(response *ErrorResponse) *status.Status {
+	st := status.New(HTTPStatusToGRPCCode(response.Status), response.Message)
+
+	var details []proto.Message
+	metadata := map[string]string{errorInfoTraceIDKey: response.TraceID}
+	for key, value := range response.Details {
+		if violations, ok := value.(url.Values); ok && key == "errors" {
+			badRequest := &errdetails.BadRequest{}
+			for field, descriptions := range violations {
+				for _, description := range descriptions {
+					badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+				}
+			}
+
+			details = append(details, badRequest)
+			continue
+		}
+
+		metadata[key] = detailToString(value)
+	}
+
+	details = append(details, &errdetails.ErrorInfo{Reason: string(response.Code), Metadata: metadata})
+	if response.RetryDelay > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(response.RetryDelay)})
+	}
+
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+
+	return withDetails
+}
+
+// FromGRPCStatus reconstructs an `*ErrorResponse` from a gRPC status, which is what the client
+// interceptors (see [UnaryClientInterceptor]) do with the statuses received from remote services.
+// The returned response is still a gRPC status, its `GRPCStatus` method returns `st`.
+//
+// The `errdetails.ErrorInfo` detail gives the error code, the trace ID and the details (as
+// strings) of the response. The `errdetails.BadRequest` detail gives the `errors` details (as
+// an `url.Values`, like [RequestValidationError] does) and the `errdetails.RetryInfo` detail gives
+// the `RetryDelay`. Without them, the error code is derived from the gRPC code and the trace ID
+// is extracted from `ctx`.
+//
+// Returns `nil` if `st` is `nil` or has code `codes.OK`.
+func FromGRPCStatus(ctx context.Context, st *status.Status) *ErrorResponse {
+	response := fromGRPCStatus(st)
+	if response != nil && response.TraceID == "" {
+		response.TraceID = traceIDFromContext(ctx)
+	}
+
+	return response
+}
+
+// fromGRPCStatus is [FromGRPCStatus] leaving the `TraceID` empty when it's not in the details
+func fromGRPCStatus(st *status.Status) *ErrorResponse {
+	if st == nil || st.Code() == codes.OK {
+		return nil
+	}
+
+	response := &ErrorResponse{
+		Code:       grpcCodeToErrorCode(st.Code()),
+		Status:     GRPCCodeToHTTPStatus(st.Code()),
+		Message:    st.Message(),
+		grpcStatus: st,
+	}
+
+	for _, detail := range st.Details() {
+		switch v := detail.(type) {
+		case *errdetails.ErrorInfo:
+			response.Code = ErrorCode(v.Reason)
+			for key, value := range v.Metadata {
+				if key == errorInfoTraceIDKey {
+					response.TraceID = value
+					continue
+				}
+
+				setDetail(response, key, value)
+			}
+
+		case *errdetails.BadRequest:
+			violations := url.Values{}
+			for _, violation := range v.FieldViolations {
+				violations.Add(violation.Field, violation.Description)
+			}
+
+			setDetail(response, "errors", violations)
+
+		case *errdetails.RetryInfo:
+			if delay, err := ptypes.Duration(v.RetryDelay); err == nil {
+				response.RetryDelay = delay
+			}
+		}
+	}
+
+	return response
+}
+
+func setDetail(response *ErrorResponse, key string, value interface{}) {
+	if response.Details == nil {
+		response.Details = map[string]interface{}{}
+	}
+
+	response.Details[key] = value
+}
+
+func grpcCodeToErrorCode(code codes.Code) ErrorCode {
+	switch code {
+	case codes.InvalidArgument:
+		return ErrorCode("request_validation_error")
+	case codes.Unavailable:
+		return ErrorCode("service_unavailable_error")
+	case codes.NotFound:
+		return ErrorCode("not_found_error")
+	}
+
+	// Turns `DeadlineExceeded` into `deadline_exceeded_error`
+	var builder strings.Builder
+	for i, char := range code.String() {
+		if unicode.IsUpper(char) {
+			if i > 0 {
+				builder.WriteRune('_')
+			}
+			char = unicode.ToLower(char)
+		}
+
+		builder.WriteRune(char)
+	}
+
+	return ErrorCode(builder.String() + "_error")
+}
+
+func detailToString(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(data)
+}
+
+// stripDebugDetails returns `st` without its `errdetails.DebugInfo` details, those must not
+// leave the process that created them.
+func stripDebugDetails(st *status.Status) *status.Status {
+	pb := st.Proto()
+
+	details := pb.Details[:0]
+	for _, detail := range pb.Details {
+		if !strings.HasSuffix(detail.TypeUrl, "/google.rpc.DebugInfo") {
+			details = append(details, detail)
+		}
+	}
+
+	pb.Details = details
+	return status.FromProto(pb)
+}
+
+// HTTPStatusToGRPCCode maps an HTTP status to the gRPC code the most closely related to it.
+func HTTPStatusToGRPCCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return codes.FailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusRequestedRangeNotSatisfiable:
+		return codes.OutOfRange
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+
+	if httpStatus >= 400 && httpStatus < 500 {
+		return codes.FailedPrecondition
+	}
+
+	return codes.Internal
+}
+
+// GRPCCodeToHTTPStatus maps a gRPC code to the HTTP status the most closely related to it.
+func GRPCCodeToHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor returns a gRPC unary client interceptor turning the statuses received
+// from remote services into `*ErrorResponse` (see [FromGRPCStatus]) so that [Is], [Find] and
+// [ToErrorResponse] work the same on local and remote errors. The returned errors are still
+// gRPC statuses, `status.Code(err)` works as before.
+//
+// When the status has no trace ID in its details, the one from the `x-trace-id` response
+// trailer is used.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var trailer metadata.MD
+
+		// Copied so that appending never writes into the backing array of the caller's options
+		callOpts := append(append(make([]grpc.CallOption, 0, len(opts)+1), opts...), grpc.Trailer(&trailer))
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+
+		return fromClientError(ctx, err, trailer)
+	}
+}
+
+// StreamClientInterceptor returns a gRPC stream client interceptor turning the statuses received
+// from remote services into `*ErrorResponse`, see [UnaryClientInterceptor] for the details.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, fromClientError(ctx, err, nil)
+		}
+
+		return &errorClientStream{ClientStream: stream, ctx: ctx}, nil
+	}
+}
+
+type errorClientStream struct {
+	grpc.ClientStream
+
+	ctx context.Context
+}
+
+func (s *errorClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	return fromClientError(s.ctx, err, s.ClientStream.Trailer())
+}
+
+func fromClientError(ctx context.Context, err error, trailer metadata.MD) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	response := fromGRPCStatus(st)
+	if response == nil {
+		return err
+	}
+
+	if response.TraceID == "" {
+		if traceIDs := trailer.Get(TraceIDMetadataKey); len(traceIDs) > 0 {
+			response.TraceID = traceIDs[0]
+		} else {
+			response.TraceID = traceIDFromContext(ctx)
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryClientInterceptor_RoundTrip(t *testing.T) {
+	serverCtx, traceID := testStreamContext()
+
+	remote := RequestValidationError(serverCtx, url.Values{"block_num": []string{"too low"}})
+	remote.RetryDelay = 2 * time.Second
+	remote.Details["extra"] = "value"
+
+	_, serverErr := UnaryServerInterceptor()(serverCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, returning(remote))
+
+	err := UnaryClientInterceptor()(context.Background(), "/test/Method", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return serverErr
+	})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	response := ToErrorResponse(context.Background(), Wrap(err, "calling remote"))
+	assert.Equal(t, C("request_validation_error"), response.Code)
+	assert.Equal(t, 400, response.Status)
+	assert.Equal(t, traceID.String(), response.TraceID)
+	assert.Equal(t, "The request is invalid.", response.Message)
+	assert.Equal(t, 2*time.Second, response.RetryDelay)
+	assert.Equal(t, map[string]interface{}{
+		"errors": url.Values{"block_num": []string{"too low"}},
+		"extra":  "value",
+	}, response.Details)
+
+	assert.NotNil(t, Find(err, func(candidate error) bool {
+		candidateResponse, ok := candidate.(*ErrorResponse)
+		return ok && candidateResponse.Code == "request_validation_error"
+	}))
+}
+
+func TestUnaryClientInterceptor_PlainStatus(t *testing.T) {
+	err := UnaryClientInterceptor()(context.Background(), "/test/Method", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, opt := range opts {
+			if trailer, ok := opt.(grpc.TrailerCallOption); ok {
+				*trailer.TrailerAddr = metadata.Pairs(TraceIDMetadataKey, "abc")
+			}
+		}
+
+		return status.Error(codes.DeadlineExceeded, "too slow")
+	})
+
+	response, ok := err.(*ErrorResponse)
+	require.True(t, ok)
+	assert.Equal(t, C("deadline_exceeded_error"), response.Code)
+	assert.Equal(t, 504, response.Status)
+	assert.Equal(t, "abc", response.TraceID)
+	assert.Equal(t, "too slow", response.Message)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestUnaryClientInterceptor_NoError(t *testing.T) {
+	err := UnaryClientInterceptor()(context.Background(), "/test/Method", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestUnaryClientInterceptor_KeepsCallerOptions(t *testing.T) {
+	opts := make([]grpc.CallOption, 1, 2)
+	opts[0] = grpc.EmptyCallOption{}
+	backing := opts[:2]
+
+	UnaryClientInterceptor()(context.Background(), "/test/Method", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}, opts...)
+
+	assert.Nil(t, backing[1], "the caller's backing array should not be written to")
+}
+
+func TestToErrorResponse_RawStatus(t *testing.T) {
+	local := HTTPTooManyRequestsError(context.Background(), nil, C("rate_limited"), "Slow down.")
+	local.RetryDelay = 3 * time.Second
+
+	response := ToErrorResponse(context.Background(), Wrap(local.GRPCStatus().Err(), "calling remote"))
+	assert.Equal(t, C("rate_limited"), response.Code)
+	assert.Equal(t, 429, response.Status)
+	assert.Equal(t, 3*time.Second, response.RetryDelay)
+	assert.Equal(t, "calling remote: Slow down.", response.Message)
+
+	response = ToErrorResponse(context.Background(), status.Error(codes.Unavailable, "down"))
+	assert.Equal(t, C("service_unavailable_error"), response.Code)
+	assert.Equal(t, 503, response.Status)
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+
+	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor returns a gRPC unary server interceptor converting any error returned
+// by the handler to a proper gRPC status (see [ToGRPCStatus]), panics included.
+//
+// Like [WriteError], the error is logged with the right level based on the actual code,
+// `Error` for codes mapping to a `>= 500` HTTP status and `Debug` for the others. The
+// trace ID is sent back to the client in the `x-trace-id` response trailer and the
+// `errdetails.DebugInfo` details are stripped from the status.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			r := recover()
+			if r != nil {
+				err = recoveredToError(ctx, info.FullMethod, r)
+			}
+
+			if err != nil {
+				var trailer metadata.MD
+				trailer, err = normalizeServerError(ctx, info.FullMethod, err, r == nil)
+				grpc.SetTrailer(ctx, trailer)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC stream server interceptor converting any error returned
+// by the handler to a proper gRPC status, see [UnaryServerInterceptor] for the details.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		ctx := ss.Context()
+
+		defer func() {
+			r := recover()
+			if r != nil {
+				err = recoveredToError(ctx, info.FullMethod, r)
+			}
+
+			if err != nil {
+				var trailer metadata.MD
+				trailer, err = normalizeServerError(ctx, info.FullMethod, err, r == nil)
+				ss.SetTrailer(trailer)
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
+
+func recoveredToError(ctx context.Context, method string, recovered interface{}) error {
+	err := NewPanicError(recovered)
+	logging.Logger(ctx, zlog).Error("recovered from panic in gRPC handler", zap.String("method", method), zap.Any("panic", recovered), zap.ByteString("stack", err.Stack))
+	RecordError("recovered from panic in gRPC handler "+method, err)
+
+	return err
+}
+
+// normalizeServerError converts `err` to the status returned to the client, along with its trailer.
+// It's logged and recorded unless `report` is `false`, when `err` already was, like a recovered panic.
+func normalizeServerError(ctx context.Context, method string, err error, report bool) (metadata.MD, error) {
+	st := stripDebugDetails(ToGRPCStatus(ctx, err))
+	if report {
+		reportServerError(ctx, method, err, st.Code())
+	}
+
+	traceID := traceIDFromContext(ctx)
+	if response := Find(err, isErrorResponse); response != nil {
+		traceID = response.(*ErrorResponse).TraceID
+	}
+
+	return metadata.Pairs(TraceIDMetadataKey, traceID), st.Err()
+}
+
+func reportServerError(ctx context.Context, method string, err error, code codes.Code) {
+	zlogger := logging.Logger(ctx, zlog)
+	if ctx.Err() == nil && GRPCCodeToHTTPStatus(code) >= 500 && !ClassifyNetworkError(err).IsClientSide() {
+		zlogger.Error("gRPC handler failed", zap.String("method", method), zap.Stringer("code", code), zap.Error(err))
+		RecordError("gRPC handler "+method+" failed", err)
+	} else {
+		zlogger.Debug("gRPC handler failed", zap.String("method", method), zap.Stringer("code", code), zap.Error(err))
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	ctx, traceID := testStreamContext()
+	interceptor := UnaryServerInterceptor()
+
+	debugStatus, err := status.New(codes.NotFound, "not here").WithDetails(&errdetails.DebugInfo{Detail: "secret"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		handler         grpc.UnaryHandler
+		expectedCode    codes.Code
+		expectedMessage string
+	}{
+		{"no error", func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }, codes.OK, ""},
+		{"plain error", returning(errors.New("test")), codes.Internal, "test"},
+		{"error response", returning(Wrap(HTTPNotFoundError(ctx, nil, C("test_error"), "Not found."), "wrapped")), codes.NotFound, "Not found."},
+		{"wrapped status", returning(Wrap(Status(codes.InvalidArgument, "invalid"), "wrapped")), codes.InvalidArgument, "wrapped: invalid"},
+		{"fatal status", returning(NewFatalError(Status(codes.PermissionDenied, "denied"))), codes.PermissionDenied, "denied"},
+		{"retryable error", returning(NewRetryableError(errors.New("test"))), codes.Unavailable, "test (retryable)"},
+		{"context canceled", returning(fmt.Errorf("stream: %w", context.Canceled)), codes.Canceled, "stream: context canceled"},
+		{"context deadline", returning(context.DeadlineExceeded), codes.DeadlineExceeded, "context deadline exceeded"},
+		{"debug info", returning(debugStatus.Err()), codes.NotFound, "not here"},
+		{"panic", func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") }, codes.Internal, "panic: boom"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, test.handler)
+
+			st := status.Convert(err)
+			assert.Equal(t, test.expectedCode, st.Code())
+			assert.Equal(t, test.expectedMessage, st.Message())
+
+			for _, detail := range st.Details() {
+				_, isDebugInfo := detail.(*errdetails.DebugInfo)
+				assert.False(t, isDebugInfo, "debug info should have been stripped")
+			}
+		})
+	}
+
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, returning(HTTPBadRequestError(ctx, nil, C("test_error"), "Bad.", "key", 1)))
+	details := status.Convert(err).Details()
+	require.Len(t, details, 1)
+	require.IsType(t, &errdetails.ErrorInfo{}, details[0])
+	assert.Equal(t, "test_error", details[0].(*errdetails.ErrorInfo).Reason)
+	assert.Equal(t, map[string]string{"trace_id": traceID.String(), "key": "1"}, details[0].(*errdetails.ErrorInfo).Metadata)
+}
+
+func TestUnaryServerInterceptor_PanicReportedOnce(t *testing.T) {
+	ctx, _ := testStreamContext()
+
+	boom := errors.New("boom")
+	UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Panic"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic(boom)
+	})
+
+	var recorded []string
+	for _, entry := range RecentErrors() {
+		if errors.Is(entry.Err, boom) {
+			recorded = append(recorded, entry.Message)
+		}
+	}
+	assert.Equal(t, []string{"recovered from panic in gRPC handler /test/Panic"}, recorded)
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	ctx, traceID := testStreamContext()
+	stream := &testServerStream{ctx: ctx}
+
+	err := StreamServerInterceptor()(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test/Stream"}, func(srv interface{}, stream grpc.ServerStream) error {
+		return HTTPServiceUnavailableError(ctx, nil, C("test_error"), "Unavailable.")
+	})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, []string{traceID.String()}, stream.trailer.Get(TraceIDMetadataKey))
+}
+
+func returning(err error) grpc.UnaryHandler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, err
+	}
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+
+	ctx     context.Context
+	trailer metadata.MD
+}
+
+func (s *testServerStream) Context() context.Context  { return s.ctx }
+func (s *testServerStream) SetTrailer(md metadata.MD) { s.trailer = metadata.Join(s.trailer, md) }
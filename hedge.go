@@ -0,0 +1,151 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Hedge executes up to `attempts` concurrent attempts of `f`, returning as soon as one of them
+// succeeds, the `ctx` of the others being canceled. The first attempt starts right away and each
+// following one starts `delay` after the previous one, or right away when an attempt fails with
+// a transient error (see [IsTransient]). It's meant for latency sensitive reads against replicated
+// backends, where a slow replica should not slow down the whole call.
+//
+// If an attempt returns a [FatalError], everything stops and its original error is returned. The
+// same goes for an error known to be permanent (see [TransientClassifier]), returned as is. If
+// all attempts fail, a [RetriesExhaustedError] is returned like [RetryContext] does. The attempt
+// number can be retrieved from the `ctx` received by `f` through [RetryAttemptFromContext].
+func Hedge(ctx context.Context, attempts uint64, delay time.Duration, f func(ctx context.Context) error, opts ...HedgeOption) error {
+	_, err := HedgeValue(ctx, attempts, delay, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, f(ctx)
+	}, opts...)
+
+	return err
+}
+
+// HedgeOption configures the behavior of [Hedge] and [HedgeValue].
+type HedgeOption func(*hedgeConfig)
+
+type hedgeConfig struct {
+	clock Clock
+}
+
+// HedgeClock makes the delay between the attempts measured using `clock`, which is [RealClock] by default.
+func HedgeClock(clock Clock) HedgeOption {
+	return func(config *hedgeConfig) {
+		config.clock = clock
+	}
+}
+
+// HedgeValue executes concurrent attempts of `f` exactly like [Hedge] does, returning the value
+// produced by the first successful attempt, or the zero value of `T` when none succeeded.
+func HedgeValue[T any](ctx context.Context, attempts uint64, delay time.Duration, f func(ctx context.Context) (T, error), opts ...HedgeOption) (T, error) {
+	if attempts == 0 {
+		attempts = 1
+	}
+
+	config := &hedgeConfig{clock: RealClock}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	type attemptResult struct {
+		attempt uint64
+		value   T
+		err     error
+	}
+
+	attemptsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so that the attempts still running when we return never block
+	results := make(chan attemptResult, attempts)
+
+	var launched uint64
+	var nextLaunch Timer
+	launch := func() {
+		launched++
+		go func(attempt uint64) {
+			value, err := f(withRetryAttempt(attemptsCtx, attempt))
+			results <- attemptResult{attempt, value, err}
+		}(launched)
+
+		if nextLaunch != nil {
+			nextLaunch.Stop()
+			nextLaunch = nil
+		}
+
+		if launched < attempts {
+			nextLaunch = config.clock.NewTimer(delay)
+		}
+	}
+	defer func() {
+		if nextLaunch != nil {
+			nextLaunch.Stop()
+		}
+	}()
+
+	var zero T
+	exhausted := &RetriesExhaustedError{}
+	start := config.clock.Now()
+
+	launch()
+	for completed := uint64(0); ; {
+		var nextLaunchC <-chan time.Time
+		if nextLaunch != nil {
+			nextLaunchC = nextLaunch.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+
+		case <-nextLaunchC:
+			launch()
+
+		case result := <-results:
+			if result.err == nil {
+				return result.value, nil
+			}
+
+			if ctx.Err() != nil {
+				return zero, ctx.Err()
+			}
+
+			var fatalError *FatalError
+			if errors.As(result.err, &fatalError) {
+				return zero, fatalError.original
+			}
+
+			if !TransientClassifier.IsRetryable(result.err) {
+				return zero, result.err
+			}
+
+			completed++
+			exhausted.record(result.attempt, result.err)
+			if completed == attempts {
+				exhausted.Attempts = attempts
+				return zero, exhausted.done(config.clock.Now().Sub(start))
+			}
+
+			if launched < attempts && IsTransient(result.err) {
+				launch()
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/derr"
+	"github.com/streamingfast/derr/derrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedge_FakeClock(t *testing.T) {
+	clock := derrtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- derr.Hedge(context.Background(), 2, time.Hour, func(ctx context.Context) error {
+			if derr.RetryAttemptFromContext(ctx) == 1 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+
+			return nil
+		}, derr.HedgeClock(clock))
+	}()
+
+	require.True(t, clock.WaitForTimers(1, 5*time.Second))
+	clock.Advance(59 * time.Minute)
+	select {
+	case err := <-done:
+		t.Fatalf("hedged attempt should not have been launched yet, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Minute)
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHedge_FirstSuccessWins(t *testing.T) {
+	var canceled int32
+	value, err := HedgeValue(context.Background(), 3, 10*time.Millisecond, func(ctx context.Context) (uint64, error) {
+		attempt := RetryAttemptFromContext(ctx)
+		if attempt == 1 {
+			// Slow replica, only returns once canceled
+			<-ctx.Done()
+			atomic.AddInt32(&canceled, 1)
+			return 0, ctx.Err()
+		}
+
+		return attempt, nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), value)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&canceled) == 1 }, time.Second, time.Millisecond)
+}
+
+func TestHedge_TransientFailureLaunchesImmediately(t *testing.T) {
+	start := time.Now()
+	err := Hedge(context.Background(), 3, time.Hour, func(ctx context.Context) error {
+		if RetryAttemptFromContext(ctx) < 3 {
+			return Status(codes.Unavailable, "unavailable")
+		}
+
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestHedge_AllFailed(t *testing.T) {
+	var count int32
+	err := Hedge(context.Background(), 3, time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&count, 1)
+		return fmt.Errorf("attempt %d failed", RetryAttemptFromContext(ctx))
+	})
+
+	var exhausted *RetriesExhaustedError
+	require.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, uint64(3), exhausted.Attempts)
+	assert.Len(t, exhausted.Errors, 3)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+}
+
+func TestHedge_FatalStopsEverything(t *testing.T) {
+	var count int32
+	err := Hedge(context.Background(), 3, 20*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&count, 1)
+		return NewFatalError(errors.New("bad request"))
+	})
+
+	assert.EqualError(t, err, "bad request")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestHedge_PermanentStopsEverything(t *testing.T) {
+	var count int32
+	err := Hedge(context.Background(), 3, 20*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&count, 1)
+		return Status(codes.InvalidArgument, "invalid block number")
+	})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestHedge_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := Hedge(ctx, 2, time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -17,10 +17,9 @@ package derr
 import (
 	"context"
 	"encoding/json"
-	"net"
+	"math"
 	"net/http"
-	"os"
-	"syscall"
+	"strconv"
 
 	"github.com/streamingfast/logging"
 
@@ -32,27 +31,43 @@ import (
 // time with the right level based on the actual status code. The `WriteError` handles
 // various type for the `err` parameter.
 func WriteError(ctx context.Context, w http.ResponseWriter, message string, err error) {
+	response, zlogger := logErrorResponse(ctx, message, err)
+	writeErrorResponse(w, response, zlogger)
+}
+
+// writeErrorResponse writes `response` to HTTP without logging it, see [WriteError].
+func writeErrorResponse(w http.ResponseWriter, response *ErrorResponse, zlogger *zap.Logger) {
+	w.Header().Set("Content-type", "application/json")
+	if response.RetryDelay > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(response.RetryDelay.Seconds())), 10))
+	}
+	w.WriteHeader(response.ResponseStatus())
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		logWriteError(zlogger, "unable to serialize error response", err)
+	}
+}
+
+// logErrorResponse turns `err` into an `ErrorResponse` and logs it at the right level based on
+// the actual status code, returning the response as well as the logger used.
+func logErrorResponse(ctx context.Context, message string, err error) (*ErrorResponse, *zap.Logger) {
 	response := ToErrorResponse(ctx, err)
 	zlogger := logging.Logger(ctx, zlog)
 
-	if ctx.Err() != context.Canceled && response.ResponseStatus() >= 500 {
+	if ctx.Err() != context.Canceled && response.ResponseStatus() >= 500 && !ClassifyNetworkError(err).IsClientSide() {
 		zlogger.Error(message, zap.Error(err))
+		RecordError(message, err)
 	} else {
 		zlogger.Debug(message, zap.Error(err))
 	}
 
-	w.Header().Set("Content-type", "application/json")
-	w.WriteHeader(response.ResponseStatus())
-
-	err = json.NewEncoder(w).Encode(response)
-	if err != nil {
-		logWriteError(zlogger, "unable to serialize error response", err)
-	}
+	return response, zlogger
 }
 
 func logWriteError(logger *zap.Logger, prefix string, err error) {
 	level := zapcore.ErrorLevel
-	if IsClientSideNetworkError(err) {
+	if ClassifyNetworkError(err).IsClientSide() {
 		level = zapcore.DebugLevel
 	}
 
@@ -61,16 +76,9 @@ func logWriteError(logger *zap.Logger, prefix string, err error) {
 
 // IsClientSideNetworkError returns wheter the error received is a network error caused by the client side
 // that could not be possibily handled correctly on the server side anyway.
+//
+// The full error(s) stack (causes chain) is inspected, see [ClassifyNetworkError] for
+// the details about the classification.
 func IsClientSideNetworkError(err error) bool {
-	netErr, isNetErr := err.(*net.OpError)
-	if !isNetErr {
-		return false
-	}
-
-	syscallErr, isSyscallErr := netErr.Err.(*os.SyscallError)
-	if !isSyscallErr {
-		return false
-	}
-
-	return syscallErr.Err == syscall.ECONNRESET || syscallErr.Err == syscall.EPIPE
+	return ClassifyNetworkError(err).IsClientSide()
 }
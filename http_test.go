@@ -22,6 +22,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	pkgErrors "github.com/pkg/errors"
 
@@ -113,3 +114,15 @@ func fixedTraceID(hexInput string) (out trace.TraceID) {
 
 	return
 }
+
+func TestWriteError_RetryAfter(t *testing.T) {
+	ctx := logging.WithLogger(context.Background(), zap.NewNop())
+	response := HTTPTooManyRequestsError(ctx, nil, C("rate_limited_error"), "Too many requests.")
+	response.RetryDelay = 1500 * time.Millisecond
+
+	recorder := httptest.NewRecorder()
+	WriteError(ctx, recorder, "prefix", response)
+
+	assert.Equal(t, 429, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+}
@@ -0,0 +1,250 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LifecycleState is the state of a [Lifecycle], the states always being traversed in order.
+type LifecycleState int
+
+const (
+	// LifecycleStarting is the initial state, the service is initializing and not ready yet
+	LifecycleStarting LifecycleState = iota
+
+	// LifecycleReady means the service is serving traffic
+	LifecycleReady
+
+	// LifecycleDraining means a termination signal was received, the service should not receive
+	// new traffic and finishes the work in progress during the grace time
+	LifecycleDraining
+
+	// LifecycleStopping means the grace time is over and the components are being torn down
+	LifecycleStopping
+
+	// LifecycleTerminated means everything is torn down, the process is about to exit
+	LifecycleTerminated
+)
+
+func (s LifecycleState) String() string {
+	switch s {
+	case LifecycleStarting:
+		return "starting"
+	case LifecycleReady:
+		return "ready"
+	case LifecycleDraining:
+		return "draining"
+	case LifecycleStopping:
+		return "stopping"
+	case LifecycleTerminated:
+		return "terminated"
+	default:
+		return "invalid"
+	}
+}
+
+func (s LifecycleState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// LifecycleStatus is a snapshot of a [Lifecycle], as served by [Lifecycle.ServeHTTP].
+type LifecycleStatus struct {
+	State LifecycleState
+	Since time.Time
+
+	// TimeInState is the time elapsed since the lifecycle entered its current state
+	TimeInState time.Duration
+
+	// GraceRemaining is the time left before the end of the grace time while draining, zero otherwise
+	GraceRemaining time.Duration
+}
+
+// LifecycleOption configures a [Lifecycle] created through [NewLifecycle].
+type LifecycleOption func(*Lifecycle)
+
+// LifecycleClock makes the lifecycle measure time using `clock`, which is [RealClock] by default.
+func LifecycleClock(clock Clock) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.clock = clock
+	}
+}
+
+// Lifecycle tracks the state of a service from its start to its termination, the single source of
+// truth of its readiness and liveness probes. The draining and stopping states are entered when
+// the [ShutdownManager] owning it receives a termination signal, the other ones are entered by
+// the service code. It's safe for concurrent use.
+type Lifecycle struct {
+	clock Clock
+
+	lock          sync.Mutex
+	state         LifecycleState
+	since         time.Time
+	graceDeadline time.Time
+	subscribers   []func(from LifecycleState, to LifecycleState)
+}
+
+// NewLifecycle creates a new [Lifecycle] in the [LifecycleStarting] state.
+func NewLifecycle(opts ...LifecycleOption) *Lifecycle {
+	l := &Lifecycle{clock: RealClock}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	l.since = l.clock.Now()
+	return l
+}
+
+// State returns the current state.
+func (l *Lifecycle) State() LifecycleState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.state
+}
+
+// Status returns a snapshot of the lifecycle.
+func (l *Lifecycle) Status() LifecycleStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	status := LifecycleStatus{State: l.state, Since: l.since, TimeInState: now.Sub(l.since)}
+	if l.state == LifecycleDraining && l.graceDeadline.After(now) {
+		status.GraceRemaining = l.graceDeadline.Sub(now)
+	}
+
+	return status
+}
+
+// Subscribe registers `callback` to be called after each state change. The callbacks are called
+// synchronously, in registration order, by the goroutine changing the state.
+func (l *Lifecycle) Subscribe(callback func(from LifecycleState, to LifecycleState)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.subscribers = append(l.subscribers, callback)
+}
+
+// MarkReady moves the lifecycle to [LifecycleReady], see [Lifecycle.Transition].
+func (l *Lifecycle) MarkReady() bool {
+	return l.Transition(LifecycleReady)
+}
+
+// MarkStopping moves the lifecycle to [LifecycleStopping], see [Lifecycle.Transition].
+func (l *Lifecycle) MarkStopping() bool {
+	return l.Transition(LifecycleStopping)
+}
+
+// MarkTerminated moves the lifecycle to [LifecycleTerminated], see [Lifecycle.Transition].
+func (l *Lifecycle) MarkTerminated() bool {
+	return l.Transition(LifecycleTerminated)
+}
+
+// Drain moves the lifecycle to [LifecycleDraining] with `grace` time left before stopping, see
+// [Lifecycle.Transition].
+func (l *Lifecycle) Drain(grace time.Duration) bool {
+	return l.transition(LifecycleDraining, grace)
+}
+
+// Transition moves the lifecycle to `to`, returning `false` without doing anything if the lifecycle
+// is already in this state or in a later one, the states being only traversed forward. Skipping
+// states is allowed, a service can go from starting to draining directly.
+func (l *Lifecycle) Transition(to LifecycleState) bool {
+	return l.transition(to, 0)
+}
+
+func (l *Lifecycle) transition(to LifecycleState, grace time.Duration) bool {
+	l.lock.Lock()
+	from := l.state
+	if to <= from {
+		l.lock.Unlock()
+		return false
+	}
+
+	l.state = to
+	l.since = l.clock.Now()
+	l.graceDeadline = l.since.Add(grace)
+	subscribers := append([]func(from LifecycleState, to LifecycleState){}, l.subscribers...)
+	l.lock.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(from, to)
+	}
+
+	return true
+}
+
+// ServeHTTP serves the lifecycle status as JSON, always with a `200` status, the durations
+// being expressed in milliseconds.
+func (l *Lifecycle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := l.Status()
+
+	w.Header().Set("Content-type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"state":              status.State,
+		"since":              status.Since,
+		"time_in_state_ms":   status.TimeInState.Milliseconds(),
+		"grace_remaining_ms": status.GraceRemaining.Milliseconds(),
+	})
+}
+
+var notReadyErrorCode = ErrorCode("not_ready_error")
+
+// ReadinessHandler returns a readiness probe handler answering `200` only while the lifecycle
+// is [LifecycleReady], `503` otherwise.
+func (l *Lifecycle) ReadinessHandler() http.Handler {
+	return l.readinessHandler(false)
+}
+
+// readinessHandler is [Lifecycle.ReadinessHandler], considering [LifecycleStarting] as ready too
+// when `readyWhileStarting` is set.
+func (l *Lifecycle) readinessHandler(readyWhileStarting bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch state := l.State(); {
+		case state == LifecycleReady, state == LifecycleStarting && readyWhileStarting:
+			w.Header().Set("Content-type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"ready":true}` + "\n"))
+		case state < LifecycleReady:
+			writeProbeError(w, r, "not ready yet", HTTPServiceUnavailableError(r.Context(), nil, notReadyErrorCode, "The service is not ready yet."))
+		default:
+			writeProbeError(w, r, "not ready, shutting down", ShuttingDownError(r.Context()))
+		}
+	})
+}
+
+// LivenessHandler returns a liveness probe handler answering `200` until the lifecycle is
+// [LifecycleTerminated], `503` afterward.
+func (l *Lifecycle) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.State() == LifecycleTerminated {
+			writeProbeError(w, r, "not alive, terminated", ShuttingDownError(r.Context()))
+			return
+		}
+
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"alive":true}` + "\n"))
+	})
+}
+
+// ProcessLifecycle returns the [Lifecycle] of the process wide [ShutdownManager].
+func ProcessLifecycle() *Lifecycle {
+	return defaultShutdownManager.Lifecycle()
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycle_Transitions(t *testing.T) {
+	lifecycle := NewLifecycle()
+
+	var transitions []string
+	lifecycle.Subscribe(func(from, to LifecycleState) {
+		transitions = append(transitions, fmt.Sprintf("%s -> %s", from, to))
+	})
+
+	assert.Equal(t, LifecycleStarting, lifecycle.State())
+	assert.True(t, lifecycle.MarkReady())
+	assert.False(t, lifecycle.MarkReady())
+	assert.True(t, lifecycle.Drain(time.Hour))
+	assert.False(t, lifecycle.Transition(LifecycleReady), "states only go forward")
+	assert.True(t, lifecycle.MarkTerminated())
+
+	assert.Equal(t, []string{
+		"starting -> ready",
+		"ready -> draining",
+		"draining -> terminated",
+	}, transitions)
+}
+
+func TestLifecycle_Status(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &steppingClock{now: now}
+	lifecycle := NewLifecycle(LifecycleClock(clock))
+
+	lifecycle.MarkReady()
+	lifecycle.Drain(5 * time.Second)
+	clock.now = clock.now.Add(2 * time.Second)
+
+	assert.Equal(t, LifecycleStatus{
+		State:          LifecycleDraining,
+		Since:          now,
+		TimeInState:    2 * time.Second,
+		GraceRemaining: 3 * time.Second,
+	}, lifecycle.Status())
+
+	recorder := httptest.NewRecorder()
+	lifecycle.ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.JSONEq(t, `{"state":"draining","since":"2020-01-01T00:00:00Z","time_in_state_ms":2000,"grace_remaining_ms":3000}`, recorder.Body.String())
+}
+
+func TestLifecycle_Probes(t *testing.T) {
+	lifecycle := NewLifecycle()
+
+	probe := func(handler http.Handler) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+		return recorder
+	}
+
+	readiness := lifecycle.ReadinessHandler()
+	liveness := lifecycle.LivenessHandler()
+
+	assert.Equal(t, 503, probe(readiness).Code)
+	assert.Contains(t, probe(readiness).Body.String(), `"code":"not_ready_error"`)
+	assert.Equal(t, 200, probe(liveness).Code)
+
+	lifecycle.MarkReady()
+	assert.Equal(t, 200, probe(readiness).Code)
+
+	lifecycle.Drain(time.Second)
+	assert.Contains(t, probe(readiness).Body.String(), `"code":"shutting_down_error"`)
+	assert.Equal(t, 200, probe(liveness).Code)
+
+	lifecycle.MarkTerminated()
+	assert.Equal(t, 503, probe(liveness).Code)
+}
+
+func TestLifecycle_DrainingMiddleware(t *testing.T) {
+	lifecycle := NewLifecycle()
+	handler := lifecycle.DrainingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "", recorder.Header().Get("Connection"))
+
+	lifecycle.Drain(time.Second)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, 204, recorder.Code)
+	assert.Equal(t, "close", recorder.Header().Get("Connection"))
+}
+
+type steppingClock struct {
+	Clock
+	now time.Time
+}
+
+func (c *steppingClock) Now() time.Time { return c.now }
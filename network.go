@@ -0,0 +1,206 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/http2"
+)
+
+// NetworkErrorKind is the classification of a network error as returned by
+// [ClassifyNetworkError].
+type NetworkErrorKind int
+
+const (
+	// NetworkErrorNone means the error is not a network error (or is `nil`).
+	NetworkErrorNone NetworkErrorKind = iota
+
+	// NetworkErrorUnknown means the error is a network error that could not be
+	// classified more precisely.
+	NetworkErrorUnknown
+
+	// NetworkErrorClientGone means the peer went away while we were talking to it
+	// (connection reset, broken pipe, connection aborted, HTTP/2 stream reset, etc.)
+	NetworkErrorClientGone
+
+	// NetworkErrorServerTimeout means the server gave up on the request because
+	// it took too long, like `http.TimeoutHandler` does.
+	NetworkErrorServerTimeout
+
+	// NetworkErrorTimeout means a network operation timed out (dial, read or i/o deadline).
+	NetworkErrorTimeout
+
+	// NetworkErrorDNS means a DNS resolution failed.
+	NetworkErrorDNS
+
+	// NetworkErrorRefused means the remote end refused the connection.
+	NetworkErrorRefused
+
+	// NetworkErrorTLS means the TLS handshake or a TLS record failed, including the
+	// verification of the peer's certificate.
+	NetworkErrorTLS
+
+	// NetworkErrorTLSAlert means the peer sent us a TLS alert, aborting the handshake
+	// or the connection.
+	NetworkErrorTLSAlert
+)
+
+func (k NetworkErrorKind) String() string {
+	switch k {
+	case NetworkErrorNone:
+		return "none"
+	case NetworkErrorUnknown:
+		return "unknown"
+	case NetworkErrorClientGone:
+		return "client_gone"
+	case NetworkErrorServerTimeout:
+		return "server_timeout"
+	case NetworkErrorTimeout:
+		return "timeout"
+	case NetworkErrorDNS:
+		return "dns"
+	case NetworkErrorRefused:
+		return "refused"
+	case NetworkErrorTLS:
+		return "tls"
+	case NetworkErrorTLSAlert:
+		return "tls_alert"
+	default:
+		return "invalid"
+	}
+}
+
+// IsClientSide returns whether this kind of network error is caused by the client side
+// when seen from a server answering a request. Those cannot be handled correctly
+// on the server side anyway. The [NetworkErrorTLS] errors are not, a certificate that
+// fails verification is a misconfiguration that must be reported.
+func (k NetworkErrorKind) IsClientSide() bool {
+	return k == NetworkErrorClientGone || k == NetworkErrorTLSAlert
+}
+
+// ClassifyNetworkError walks the error(s) stack (causes chain) of `err` and returns
+// the kind of the first network error found in it. Returns [NetworkErrorNone] if `err`
+// is `nil` or if it's not a network error.
+func ClassifyNetworkError(err error) NetworkErrorKind {
+	kind := NetworkErrorNone
+	Walk(err, func(candidateErr error) (bool, error) {
+		if candidateErr == nil {
+			return false, nil
+		}
+
+		kind = classifyNetworkError(candidateErr, kind)
+
+		// We stop as soon as we have a precise classification, otherwise we continue
+		// walking, a deeper error might be more precise.
+		return kind == NetworkErrorNone || kind == NetworkErrorUnknown, nil
+	})
+
+	return kind
+}
+
+func classifyNetworkError(err error, current NetworkErrorKind) NetworkErrorKind {
+	if err == http.ErrHandlerTimeout {
+		return NetworkErrorServerTimeout
+	}
+
+	switch v := err.(type) {
+	case syscall.Errno:
+		return classifyErrno(v, current)
+
+	case *os.SyscallError:
+		return classifyErrno(v.Err, current)
+
+	case *net.DNSError:
+		return NetworkErrorDNS
+
+	case *net.OpError:
+		// Alerts received from the peer are reported by `crypto/tls` wrapped in
+		// a `*net.OpError` with this exact operation name.
+		if v.Op == "remote error" {
+			return NetworkErrorTLSAlert
+		}
+
+		if v.Op == "write" && isErrno(v.Err, syscall.ETIMEDOUT) {
+			return NetworkErrorClientGone
+		}
+
+		return NetworkErrorUnknown
+
+	case tls.RecordHeaderError, x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError:
+		return NetworkErrorTLS
+
+	case http2.StreamError:
+		if v.Code == http2.ErrCodeCancel || v.Code == http2.ErrCodeStreamClosed {
+			return NetworkErrorClientGone
+		}
+
+		return NetworkErrorUnknown
+
+	case http2.GoAwayError:
+		return NetworkErrorClientGone
+	}
+
+	// The HTTP/2 errors of the implementation bundled in `net/http` are unexported, we
+	// have no other choice than matching on their message.
+	message := err.Error()
+	if message == "http2: stream closed" || message == "client disconnected" {
+		return NetworkErrorClientGone
+	}
+
+	if strings.HasPrefix(message, "stream error: ") && (strings.Contains(message, "CANCEL") || strings.Contains(message, "STREAM_CLOSED")) {
+		return NetworkErrorClientGone
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && err != context.DeadlineExceeded {
+		return NetworkErrorTimeout
+	}
+
+	return current
+}
+
+func classifyErrno(err error, current NetworkErrorKind) NetworkErrorKind {
+	errno, ok := err.(syscall.Errno)
+	if !ok {
+		return current
+	}
+
+	switch errno {
+	case syscall.ECONNRESET, syscall.EPIPE, syscall.ECONNABORTED:
+		return NetworkErrorClientGone
+	case syscall.ECONNREFUSED:
+		return NetworkErrorRefused
+	case syscall.ETIMEDOUT:
+		return NetworkErrorTimeout
+	}
+
+	return current
+}
+
+func isErrno(err error, expected syscall.Errno) bool {
+	if syscallErr, ok := err.(*os.SyscallError); ok {
+		err = syscallErr.Err
+	}
+
+	errno, ok := err.(syscall.Errno)
+	return ok && errno == expected
+}
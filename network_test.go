@@ -0,0 +1,80 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func TestClassifyNetworkError(t *testing.T) {
+	opErr := func(op string, err error) error {
+		return &net.OpError{Op: op, Net: "tcp", Err: err}
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected NetworkErrorKind
+	}{
+		{"nil", nil, NetworkErrorNone},
+		{"plain error", errors.New("test"), NetworkErrorNone},
+		{"context deadline", context.DeadlineExceeded, NetworkErrorNone},
+		{"connection reset", opErr("write", os.NewSyscallError("write", syscall.ECONNRESET)), NetworkErrorClientGone},
+		{"broken pipe", opErr("write", os.NewSyscallError("write", syscall.EPIPE)), NetworkErrorClientGone},
+		{"connection aborted", opErr("read", os.NewSyscallError("read", syscall.ECONNABORTED)), NetworkErrorClientGone},
+		{"wrapped connection reset", fmt.Errorf("copy: %w", opErr("write", os.NewSyscallError("write", syscall.ECONNRESET))), NetworkErrorClientGone},
+		{"timed out on write", opErr("write", os.NewSyscallError("write", syscall.ETIMEDOUT)), NetworkErrorClientGone},
+		{"timed out on read", opErr("read", os.NewSyscallError("read", syscall.ETIMEDOUT)), NetworkErrorTimeout},
+		{"connection refused", opErr("dial", os.NewSyscallError("connect", syscall.ECONNREFUSED)), NetworkErrorRefused},
+		{"dns", opErr("dial", &net.DNSError{Err: "no such host", Name: "example"}), NetworkErrorDNS},
+		{"tls alert", opErr("remote error", errors.New("tls: bad certificate")), NetworkErrorTLSAlert},
+		{"tls unknown authority", Wrap(x509.UnknownAuthorityError{}, "dial"), NetworkErrorTLS},
+		{"tls hostname", x509.HostnameError{Host: "example"}, NetworkErrorTLS},
+		{"http2 stream closed", errors.New("http2: stream closed"), NetworkErrorClientGone},
+		{"http2 client disconnected", Wrap(errors.New("client disconnected"), "write"), NetworkErrorClientGone},
+		{"http2 stream reset", errors.New("stream error: stream ID 3; CANCEL"), NetworkErrorClientGone},
+		{"x/net http2 stream reset", fmt.Errorf("write: %w", http2.StreamError{StreamID: 3, Code: http2.ErrCodeCancel}), NetworkErrorClientGone},
+		{"x/net http2 stream internal error", http2.StreamError{StreamID: 3, Code: http2.ErrCodeInternal}, NetworkErrorUnknown},
+		{"x/net http2 go away", http2.GoAwayError{ErrCode: http2.ErrCodeNo}, NetworkErrorClientGone},
+		{"handler timeout", Wrap(http.ErrHandlerTimeout, "write"), NetworkErrorServerTimeout},
+		{"unknown network error", opErr("read", errors.New("unknown")), NetworkErrorUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ClassifyNetworkError(test.err))
+		})
+	}
+}
+
+func TestIsClientSideNetworkError(t *testing.T) {
+	assert.True(t, IsClientSideNetworkError(Wrap(&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, "write")))
+	assert.True(t, IsClientSideNetworkError(&net.OpError{Op: "remote error", Err: errors.New("tls: unknown certificate")}))
+	assert.False(t, IsClientSideNetworkError(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}))
+	assert.False(t, IsClientSideNetworkError(errors.New("test")))
+	assert.False(t, IsClientSideNetworkError(x509.UnknownAuthorityError{}))
+	assert.False(t, IsClientSideNetworkError(x509.HostnameError{Host: "example"}))
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
+)
+
+// PanicError is a recovered panic turned into an error, see [Recover] and [Go]. When the panic
+// value is an error, it's the cause of the [PanicError], visible to [Walk] and [DebugErrorChain].
+type PanicError struct {
+	// Value is the value the code panicked with
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine that panicked, captured when recovering
+	Stack []byte
+}
+
+// NewPanicError creates a new [PanicError] from the `recovered` value, capturing the stack of the
+// current goroutine, it must be called from the deferred function that recovered the panic.
+func NewPanicError(recovered interface{}) *PanicError {
+	return &PanicError{Value: recovered, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Recover turns a panic into a [PanicError] assigned to `err`, it must be deferred directly by
+// the function whose panics should be recovered, typically with a named error return value:
+//
+//	func process() (err error) {
+//		defer derr.Recover(&err)
+//		...
+//	}
+func Recover(err *error) {
+	if recovered := recover(); recovered != nil {
+		*err = NewPanicError(recovered)
+	}
+}
+
+// GoOption configures a goroutine started by [Go].
+type GoOption func(*goConfig)
+
+type goConfig struct {
+	shutdownManager *ShutdownManager
+}
+
+// GoShutdownOnPanic is [ShutdownManager.GoShutdownOnPanic] on the process wide [ShutdownManager].
+func GoShutdownOnPanic() GoOption {
+	return defaultShutdownManager.GoShutdownOnPanic()
+}
+
+// GoShutdownOnPanic makes a panic of a goroutine started by [Go] start a graceful shutdown through
+// the manager, see [ShutdownManager.RequestShutdown], the panic being reported to `onErr` as usual.
+// When the manager has no active signal handler to shut down gracefully, the process exits right
+// away with [ExitSoftware] through the manager's exit function (see [SignalExitFunc]).
+func (m *ShutdownManager) GoShutdownOnPanic() GoOption {
+	return func(config *goConfig) {
+		config.shutdownManager = m
+	}
+}
+
+// Go runs `fn` in a new goroutine, calling `onErr` with the error it returned, if any, a panic
+// being turned into a [PanicError]. When `onErr` is `nil`, the error is logged instead, using
+// the logger of `ctx` so that the trace ID is part of it. `name` identifies the goroutine in
+// the logs.
+func Go(ctx context.Context, name string, fn func(ctx context.Context) error, onErr func(err error), opts ...GoOption) {
+	config := &goConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	go func() {
+		err := runRecovered(ctx, fn)
+		if err == nil {
+			return
+		}
+
+		panicErr, panicked := err.(*PanicError)
+		if panicked {
+			logging.Logger(ctx, zlog).Error("recovered from panic in goroutine", zap.String("goroutine", name), zap.Any("panic", panicErr.Value), zap.ByteString("stack", panicErr.Stack))
+			RecordError("recovered from panic in goroutine "+name, err)
+		}
+
+		if onErr != nil {
+			onErr(err)
+		} else if !panicked {
+			logging.Logger(ctx, zlog).Error("goroutine failed", zap.String("goroutine", name), zap.Error(err))
+			RecordError("goroutine "+name+" failed", err)
+		}
+
+		if panicked && config.shutdownManager != nil {
+			shutdownOnPanic(config.shutdownManager, name)
+		}
+	}()
+}
+
+func shutdownOnPanic(manager *ShutdownManager, name string) {
+	if manager.RequestShutdown() {
+		return
+	}
+
+	manager.config.logger.Error("no signal handler to gracefully shut down after panic in goroutine, exiting", zap.String("goroutine", name))
+	manager.config.logger.Sync()
+	manager.config.exit(ExitSoftware)
+}
+
+func runRecovered(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer Recover(&err)
+
+	return fn(ctx)
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecover(t *testing.T) {
+	process := func() (err error) {
+		defer Recover(&err)
+
+		panic(io.ErrUnexpectedEOF)
+	}
+
+	err := process()
+
+	var panicErr *PanicError
+	require.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, io.ErrUnexpectedEOF, panicErr.Value)
+	assert.Contains(t, string(panicErr.Stack), "TestRecover")
+	assert.EqualError(t, err, "panic: unexpected EOF")
+
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.Equal(t, "*derr.PanicError | panic: unexpected EOF\n*errors.errorString | unexpected EOF", DebugErrorChain(err))
+
+	noPanic := func() (err error) {
+		defer Recover(&err)
+		return nil
+	}
+	assert.NoError(t, noPanic())
+}
+
+func TestGo(t *testing.T) {
+	errs := make(chan error, 1)
+
+	Go(context.Background(), "worker", func(ctx context.Context) error {
+		panic("boom")
+	}, func(err error) { errs <- err })
+
+	select {
+	case err := <-errs:
+		var panicErr *PanicError
+		require.True(t, errors.As(err, &panicErr))
+		assert.Equal(t, "boom", panicErr.Value)
+	case <-time.After(time.Second):
+		t.Fatal("onErr should have been called")
+	}
+
+	Go(context.Background(), "worker", func(ctx context.Context) error {
+		return errors.New("failed")
+	}, func(err error) { errs <- err })
+	assert.EqualError(t, <-errs, "failed")
+}
+
+func TestGo_ShutdownOnPanic(t *testing.T) {
+	previous := defaultShutdownManager
+	defer func() { defaultShutdownManager = previous }()
+
+	defaultShutdownManager = NewShutdownManager(SignalNotify(), SignalGracefulDelay(time.Hour))
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	_, draining := defaultShutdownManager.SignalContext(parent)
+	Go(context.Background(), "worker", func(ctx context.Context) error {
+		panic("boom")
+	}, func(err error) {}, GoShutdownOnPanic())
+
+	select {
+	case <-draining.Done():
+	case <-time.After(time.Second):
+		t.Fatal("a graceful shutdown should have been requested")
+	}
+
+	assert.True(t, defaultShutdownManager.IsShuttingDown())
+}
+
+func TestShutdownManager_GoShutdownOnPanic(t *testing.T) {
+	manager := NewShutdownManager(SignalNotify(), SignalGracefulDelay(time.Hour))
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	_, draining := manager.SignalContext(parent)
+	Go(context.Background(), "worker", func(ctx context.Context) error {
+		panic("boom")
+	}, func(err error) {}, manager.GoShutdownOnPanic())
+
+	select {
+	case <-draining.Done():
+	case <-time.After(time.Second):
+		t.Fatal("a graceful shutdown should have been requested")
+	}
+
+	assert.True(t, manager.IsShuttingDown())
+	assert.False(t, defaultShutdownManager.IsShuttingDown(), "the process wide manager should not be affected")
+}
+
+func TestShutdownManager_GoShutdownOnPanic_NoSignalHandler(t *testing.T) {
+	exitCodes := make(chan int, 1)
+	manager := NewShutdownManager(SignalNotify(), SignalExitFunc(func(code int) { exitCodes <- code }))
+
+	Go(context.Background(), "worker", func(ctx context.Context) error {
+		panic("boom")
+	}, func(err error) {}, manager.GoShutdownOnPanic())
+
+	select {
+	case code := <-exitCodes:
+		assert.Equal(t, ExitSoftware, code)
+	case <-time.After(time.Second):
+		t.Fatal("the process should have exited without a signal handler to shut down gracefully")
+	}
+}
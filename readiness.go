@@ -0,0 +1,111 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
+)
+
+// ReadinessHandler is an `http.HandlerFunc` answering readiness probes from the process
+// wide [Lifecycle] (see [ProcessLifecycle]). It returns a `200 OK` until the signal handler
+// (see [SetupSignalHandler]) starts draining, at which point it returns a `503`
+// [ShuttingDownError] so that load balancers stop routing traffic to this instance during the
+// graceful shutdown delay.
+//
+// Unlike [Lifecycle.ReadinessHandler], the service is considered ready while still
+// [LifecycleStarting], use the latter to answer `503` until [Lifecycle.MarkReady] is called.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	ProcessLifecycle().readinessHandler(true).ServeHTTP(w, r)
+}
+
+// DrainingOption configures the behavior of [DrainingMiddleware].
+type DrainingOption func(*drainingConfig)
+
+type drainingConfig struct {
+	rejectMatcher func(r *http.Request) bool
+}
+
+// DrainingRejectWhen makes [DrainingMiddleware] reject with a `503` [ShuttingDownError]
+// every new request matching `matcher` received while the service is draining.
+func DrainingRejectWhen(matcher func(r *http.Request) bool) DrainingOption {
+	return func(config *drainingConfig) {
+		config.rejectMatcher = matcher
+	}
+}
+
+// DrainingRejectLongLived makes [DrainingMiddleware] reject new long-lived requests
+// (see [IsLongLivedRequest]) received while the service is draining.
+func DrainingRejectLongLived() DrainingOption {
+	return DrainingRejectWhen(IsLongLivedRequest)
+}
+
+// DrainingMiddleware is [Lifecycle.DrainingMiddleware] on the process wide [Lifecycle], see
+// [ProcessLifecycle].
+func DrainingMiddleware(next http.Handler, opts ...DrainingOption) http.Handler {
+	return drainingMiddleware(ProcessLifecycle, next, opts)
+}
+
+// DrainingMiddleware wraps `next` so that once the lifecycle is draining (or in a later state),
+// every response carries a `Connection: close` header, forcing clients and load balancers to
+// re-establish their connection, hopefully to another instance.
+//
+// By default, all requests are still served while draining, use [DrainingRejectWhen] or
+// [DrainingRejectLongLived] to reject some of them right away.
+func (l *Lifecycle) DrainingMiddleware(next http.Handler, opts ...DrainingOption) http.Handler {
+	return drainingMiddleware(func() *Lifecycle { return l }, next, opts)
+}
+
+func drainingMiddleware(lifecycle func() *Lifecycle, next http.Handler, opts []DrainingOption) http.Handler {
+	config := &drainingConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lifecycle().State() >= LifecycleDraining {
+			w.Header().Set("Connection", "close")
+
+			if config.rejectMatcher != nil && config.rejectMatcher(r) {
+				writeProbeError(w, r, "rejecting request, shutting down", ShuttingDownError(r.Context()))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsLongLivedRequest returns whether the request is expected to hold its connection for
+// a long time, which is the case of WebSocket upgrades and Server-Sent Events streams.
+func IsLongLivedRequest(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// writeProbeError answers `r` with `response`. Unlike [WriteError], it's logged at the debug level
+// and not recorded, it's the expected answer while starting or draining.
+func writeProbeError(w http.ResponseWriter, r *http.Request, message string, response *ErrorResponse) {
+	zlogger := logging.Logger(r.Context(), zlog)
+
+	zlogger.Debug(message, zap.Error(response))
+	writeErrorResponse(w, response, zlogger)
+}
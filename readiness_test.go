@@ -0,0 +1,97 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		ReadinessHandler(recorder, httptest.NewRequest("GET", "/healthz", nil))
+
+		return recorder
+	}
+
+	withProcessLifecycle(t, func(lifecycle *Lifecycle) {
+		recorded := len(RecentErrors())
+
+		assert.Equal(t, 200, serve().Code, "a service not using the lifecycle is ready right away")
+
+		lifecycle.MarkReady()
+		assert.Equal(t, 200, serve().Code)
+
+		lifecycle.Drain(0)
+		recorder := serve()
+		assert.Equal(t, 503, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), `"code":"shutting_down_error"`)
+
+		assert.Len(t, RecentErrors(), recorded, "starting and draining are expected, they should not be recorded as errors")
+	})
+}
+
+func TestDrainingMiddleware(t *testing.T) {
+	handler := DrainingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), DrainingRejectLongLived())
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Accept", accept)
+		handler.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	recorder := serve("text/event-stream")
+	assert.Equal(t, 204, recorder.Code)
+	assert.Equal(t, "", recorder.Header().Get("Connection"))
+
+	withShuttingDown(t, func() {
+		recorder := serve("application/json")
+		assert.Equal(t, 204, recorder.Code)
+		assert.Equal(t, "close", recorder.Header().Get("Connection"))
+
+		recorder = serve("text/event-stream")
+		assert.Equal(t, 503, recorder.Code)
+		assert.Equal(t, "close", recorder.Header().Get("Connection"))
+	})
+}
+
+func withShuttingDown(t *testing.T, f func()) {
+	t.Helper()
+
+	withProcessLifecycle(t, func(lifecycle *Lifecycle) {
+		lifecycle.Drain(0)
+		f()
+	})
+}
+
+// withProcessLifecycle calls `f` with a fresh process wide [Lifecycle], see [ProcessLifecycle].
+func withProcessLifecycle(t *testing.T, f func(lifecycle *Lifecycle)) {
+	t.Helper()
+
+	previous := defaultShutdownManager
+	defer func() { defaultShutdownManager = previous }()
+
+	defaultShutdownManager = NewShutdownManager()
+	f(defaultShutdownManager.Lifecycle())
+}
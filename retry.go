@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"time"
 
-	retry "github.com/sethvargo/go-retry"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
 )
 
 type FatalError struct {
@@ -33,9 +35,10 @@ func (r *FatalError) Error() string {
 
 // RetryableError can be returned by your handler either [SinkerHandlers#HandleBlockScopedData] or
 // [SinkerHandlers#HandleBlockUndoSignal] to notify the sinker that it's a retryable error and the
-// stream can continue
+// stream can continue. It's also the error retried by [RetryContext] under the [RetryStrict] policy.
 type RetryableError struct {
 	original error
+	delay    time.Duration
 }
 
 // NewRetryableError creates a new [RetryableError] struct ensuring `original` error is non-nil
@@ -45,7 +48,22 @@ func NewRetryableError(original error) *RetryableError {
 		panic(fmt.Errorf("the 'original' argument is mandatory"))
 	}
 
-	return &RetryableError{original}
+	return &RetryableError{original: original}
+}
+
+// NewRetryableErrorWithDelay creates a new [RetryableError] struct like [NewRetryableError] does
+// that also tells [RetryContext] to wait at least `delay` before the next attempt, typically
+// because the upstream asked for it.
+func NewRetryableErrorWithDelay(original error, delay time.Duration) *RetryableError {
+	err := NewRetryableError(original)
+	err.delay = delay
+
+	return err
+}
+
+// RetryDelay returns the minimum delay to wait before retrying, zero if none was specified.
+func (r *RetryableError) RetryDelay() time.Duration {
+	return r.delay
 }
 
 func (r *RetryableError) Unwrap() error {
@@ -56,31 +74,211 @@ func (r *RetryableError) Error() string {
 	return fmt.Sprintf("%s (retryable)", r.original)
 }
 
+func isRetryableError(err error) bool {
+	_, ok := err.(*RetryableError)
+	return ok
+}
+
 // Retry re-executes the function `f` if it returns an error. If you return a  `derr.FatalError` your function
 // will not be retried.
-func Retry(retries uint64, f func(ctx context.Context) error) error {
-	return RetryContext(context.Background(), retries, f)
+//
+// By default, the delay between attempts follows a Fibonacci curve starting at 1s and capped at 5s,
+// use `opts` to configure it, see [RetryBackoff] and the other `Retry...` options.
+func Retry(retries uint64, f func(ctx context.Context) error, opts ...RetryOption) error {
+	return RetryContext(context.Background(), retries, f, opts...)
 }
 
 // RetryContext re-executes the function `f` if it returns an error. If you return a  `derr.FatalError` your function
 // will not be retried.
-func RetryContext(ctx context.Context, retries uint64, f func(ctx context.Context) error) error {
-	return retry.Do(ctx, backoff(retries), func(ctx context.Context) error {
-		err := f(ctx)
-		if err != nil {
-			var fatalError *FatalError
-			if errors.As(err, &fatalError) {
-				return fatalError.original
+//
+// By default, the delay between attempts follows a Fibonacci curve starting at 1s and capped at 5s,
+// use `opts` to configure it, see [RetryBackoff] and the other `Retry...` options. A delay hinted
+// by the failed attempt (see [RetryDelayHint]) is waited instead when longer, up to the same 5s
+// cap by default, see [RetryMaxHintedDelay].
+func RetryContext(ctx context.Context, retries uint64, f func(ctx context.Context) error, opts ...RetryOption) error {
+	config := newRetryConfig(opts)
+	backoff := config.backoff(retries)
+	exhausted := &RetriesExhaustedError{}
+	start := config.clock.Now()
+
+	for attempt := uint64(1); ; attempt++ {
+		// Return immediately if ctx is canceled
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		err := config.attempt(withRetryAttempt(ctx, attempt), f)
+		if err == nil {
+			if config.budget != nil {
+				config.budget.deposit()
 			}
-			return retry.RetryableError(err)
+
+			return nil
+		}
+
+		var fatalError *FatalError
+		if errors.As(err, &fatalError) {
+			return fatalError.original
+		}
+
+		if !config.classifier.IsRetryable(err) || Find(err, isCircuitOpenError) != nil {
+			return err
+		}
+
+		exhausted.record(attempt, err)
+
+		delay, stop := backoff.Next()
+		if stop {
+			return exhausted.done(config.clock.Now().Sub(start))
+		}
+
+		if config.budget != nil && !config.budget.tryWithdraw() {
+			return &RetryBudgetExhaustedError{Attempts: attempt, last: err}
+		}
+
+		if hinted := config.capHintedDelay(RetryDelayHint(err)); hinted > delay {
+			delay = hinted
+		}
+
+		if config.maxElapsedTime > 0 {
+			remaining := config.maxElapsedTime - config.clock.Now().Sub(start)
+			if remaining <= 0 {
+				return exhausted.done(config.clock.Now().Sub(start))
+			}
+
+			if delay > remaining {
+				delay = remaining
+			}
+		}
+
+		for _, hook := range config.onRetry {
+			hook(attempt, err, delay)
+		}
+
+		timer := config.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// RetryValue re-executes the function `f` if it returns an error exactly like [RetryContext] does,
+// returning the value produced by the first successful attempt. The value returned alongside an
+// error by a failed attempt is always discarded, the zero value of `T` being returned when all
+// attempts failed.
+func RetryValue[T any](ctx context.Context, retries uint64, f func(ctx context.Context) (T, error), opts ...RetryOption) (T, error) {
+	var result T
+	err := RetryContext(ctx, retries, func(ctx context.Context) error {
+		value, err := f(ctx)
+		if err != nil {
+			return err
 		}
+
+		result = value
 		return nil
-	})
+	}, opts...)
+
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return result, nil
+}
+
+// RetriesExhaustedError is returned by [RetryContext] when all the attempts failed. It unwraps
+// to the error returned by the last attempt.
+type RetriesExhaustedError struct {
+	// Attempts is the number of attempts made
+	Attempts uint64
+
+	// Elapsed is the total time spent, from the first attempt until giving up
+	Elapsed time.Duration
+
+	// Errors are the distinct errors (by message) returned by the attempts, in the order
+	// they were first seen, the last one not necessarily being the error of the last attempt.
+	Errors []error
+
+	last error
+}
+
+func (e *RetriesExhaustedError) record(attempt uint64, err error) {
+	e.Attempts = attempt
+	e.last = err
+
+	for _, seen := range e.Errors {
+		if seen.Error() == err.Error() {
+			return
+		}
+	}
+
+	e.Errors = append(e.Errors, err)
+}
+
+func (e *RetriesExhaustedError) done(elapsed time.Duration) *RetriesExhaustedError {
+	e.Elapsed = elapsed
+	return e
 }
 
-func backoff(maxretries uint64) retry.Backoff {
-	b := retry.NewFibonacci(time.Second)
-	b = retry.WithMaxRetries(maxretries, b)
-	b = retry.WithCappedDuration(5*time.Second, b)
-	return b
+// Last returns the error returned by the last attempt.
+func (e *RetriesExhaustedError) Last() error {
+	return e.last
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.last
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	distinct := ""
+	if len(e.Errors) > 1 {
+		distinct = fmt.Sprintf(", %d distinct errors", len(e.Errors))
+	}
+
+	return fmt.Sprintf("retries exhausted after %d attempts in %s%s: %s", e.Attempts, e.Elapsed.Round(time.Millisecond), distinct, e.last)
+}
+
+type retryAttemptKey struct{}
+
+func withRetryAttempt(ctx context.Context, attempt uint64) context.Context {
+	return context.WithValue(ctx, retryAttemptKey{}, attempt)
+}
+
+// RetryAttemptFromContext returns the attempt number (starting at 1) of the function currently
+// executed by [RetryContext], `0` if `ctx` is not the one received by such function.
+func RetryAttemptFromContext(ctx context.Context) uint64 {
+	attempt, _ := ctx.Value(retryAttemptKey{}).(uint64)
+	return attempt
+}
+
+// RetryDelayHint walks the error(s) stack (causes chain) and returns the first minimum retry
+// delay found in it, zero if none. The delay can come from a [RetryableError] created with
+// [NewRetryableErrorWithDelay], from a [CircuitOpenError], from an [ErrorResponse] with a
+// `RetryDelay` or from a gRPC status with an `errdetails.RetryInfo` detail.
+func RetryDelayHint(err error) (delay time.Duration) {
+	Walk(err, func(candidateErr error) (bool, error) {
+		switch v := candidateErr.(type) {
+		case nil:
+			return false, nil
+		case interface{ RetryDelay() time.Duration }:
+			delay = v.RetryDelay()
+		case *ErrorResponse:
+			delay = v.RetryDelay
+		case interface{ GRPCStatus() *status.Status }:
+			for _, detail := range v.GRPCStatus().Details() {
+				if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+					delay, _ = ptypes.Duration(retryInfo.RetryDelay)
+				}
+			}
+		}
+
+		return delay <= 0, nil
+	})
+
+	return delay
 }
@@ -0,0 +1,162 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RetryBudget limits the retries made by all the [RetryContext] callers sharing it, preventing
+// retry storms during partial outages. It's a token bucket: each successful call deposits `ratio`
+// token, the bucket is also refilled at `minRetriesPerSecond` tokens per second, and each retry
+// withdraws one token. When the bucket is empty, the retry is not made and the [RetryContext]
+// call fails fast with a [RetryBudgetExhaustedError].
+//
+// The balance is capped at what 100 successful calls plus 10 seconds of refill deposit, so a
+// long period without failures cannot accumulate an unbounded number of retries.
+//
+// It's safe for concurrent use and is attached to [RetryContext] with [RetryWithBudget].
+type RetryBudget struct {
+	ratio               float64
+	minRetriesPerSecond float64
+	maxBalance          float64
+	clock               Clock
+
+	lock        sync.Mutex
+	balance     float64
+	lastRefill  time.Time
+	deposits    uint64
+	withdrawals uint64
+	rejections  uint64
+}
+
+// RetryBudgetStats is a snapshot of the state of a [RetryBudget], meant to be exported as metrics.
+type RetryBudgetStats struct {
+	// Balance is the number of retries currently allowed
+	Balance float64
+
+	// Deposits is the number of successful calls recorded so far
+	Deposits uint64
+
+	// Withdrawals is the number of retries allowed so far
+	Withdrawals uint64
+
+	// Rejections is the number of retries refused so far because the budget was exhausted
+	Rejections uint64
+}
+
+// NewRetryBudget creates a new [RetryBudget] allowing `ratio` retry per successful call (0.1
+// allows retrying 10% of the calls) plus `minRetriesPerSecond` retries per second no matter
+// how many calls succeeded. The budget starts full at the minimum rate, 10 seconds worth of it.
+func NewRetryBudget(ratio float64, minRetriesPerSecond float64, opts ...RetryBudgetOption) *RetryBudget {
+	if ratio < 0 || minRetriesPerSecond < 0 {
+		panic(fmt.Errorf("the 'ratio' and 'minRetriesPerSecond' arguments must be positive, got %f and %f", ratio, minRetriesPerSecond))
+	}
+
+	b := &RetryBudget{
+		ratio:               ratio,
+		minRetriesPerSecond: minRetriesPerSecond,
+		maxBalance:          100*ratio + 10*minRetriesPerSecond,
+		balance:             10 * minRetriesPerSecond,
+		clock:               RealClock,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.lastRefill = b.clock.Now()
+	return b
+}
+
+// RetryBudgetOption configures a [RetryBudget] created through [NewRetryBudget].
+type RetryBudgetOption func(*RetryBudget)
+
+// RetryBudgetClock makes the budget measure time using `clock`, which is [RealClock] by default.
+func RetryBudgetClock(clock Clock) RetryBudgetOption {
+	return func(b *RetryBudget) {
+		b.clock = clock
+	}
+}
+
+// Stats returns the current state of the budget.
+func (b *RetryBudget) Stats() RetryBudgetStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(b.clock.Now())
+	return RetryBudgetStats{
+		Balance:     b.balance,
+		Deposits:    b.deposits,
+		Withdrawals: b.withdrawals,
+		Rejections:  b.rejections,
+	}
+}
+
+// deposit records a successful call.
+func (b *RetryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(b.clock.Now())
+	b.deposits++
+	b.balance = math.Min(b.maxBalance, b.balance+b.ratio)
+}
+
+// tryWithdraw returns whether a retry is allowed, consuming one token if it's the case.
+func (b *RetryBudget) tryWithdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(b.clock.Now())
+	if b.balance < 1 {
+		b.rejections++
+		return false
+	}
+
+	b.withdrawals++
+	b.balance--
+	return true
+}
+
+func (b *RetryBudget) refill(now time.Time) {
+	elapsed := now.Sub(b.lastRefill)
+	if elapsed <= 0 {
+		return
+	}
+
+	b.lastRefill = now
+	b.balance = math.Min(b.maxBalance, b.balance+elapsed.Seconds()*b.minRetriesPerSecond)
+}
+
+// RetryBudgetExhaustedError is returned by [RetryContext] when an attempt failed but could not
+// be retried because the [RetryBudget] is exhausted. It unwraps to the error of the last attempt.
+type RetryBudgetExhaustedError struct {
+	// Attempts is the number of attempts made
+	Attempts uint64
+
+	last error
+}
+
+func (e *RetryBudgetExhaustedError) Unwrap() error {
+	return e.last
+}
+
+func (e *RetryBudgetExhaustedError) Error() string {
+	return fmt.Sprintf("retry budget exhausted after %d attempts: %s", e.Attempts, e.last)
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBudget(t *testing.T) {
+	budget := NewRetryBudget(0.5, 0)
+	fast := RetryBackoff(BackoffConstant, time.Millisecond, 0)
+
+	succeeding := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("failed") }
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, RetryContext(context.Background(), 5, succeeding, fast, RetryWithBudget(budget)))
+	}
+	assert.Equal(t, RetryBudgetStats{Balance: 2, Deposits: 4}, budget.Stats())
+
+	err := RetryContext(context.Background(), 5, failing, fast, RetryWithBudget(budget))
+
+	var budgetExhausted *RetryBudgetExhaustedError
+	require.True(t, errors.As(err, &budgetExhausted))
+	assert.Equal(t, uint64(3), budgetExhausted.Attempts)
+	assert.EqualError(t, errors.Unwrap(err), "failed")
+	assert.Equal(t, RetryBudgetStats{Balance: 0, Deposits: 4, Withdrawals: 2, Rejections: 1}, budget.Stats())
+}
+
+func TestRetryBudget_MinRetriesPerSecond(t *testing.T) {
+	budget := NewRetryBudget(0, 100)
+	assert.InDelta(t, 1000, budget.Stats().Balance, 1)
+
+	for i := 0; i < 1000; i++ {
+		budget.tryWithdraw()
+	}
+	assert.True(t, budget.Stats().Balance < 1)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, budget.Stats().Balance >= 1)
+}
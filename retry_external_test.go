@@ -0,0 +1,105 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/derr"
+	"github.com/streamingfast/derr/derrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryContext(t *testing.T) {
+	var count int
+	err, elapsed := runWithFakeClock(t, func(clock derr.Clock) error {
+		return derr.RetryContext(context.Background(), 2, func(ctx context.Context) error {
+			count++
+			return fmt.Errorf("I failed")
+		}, derr.RetryClock(clock))
+	})
+	assert.Error(t, err)
+	assert.EqualError(t, errors.Unwrap(err), "I failed")
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 3*time.Second, elapsed)
+
+	var exhausted *derr.RetriesExhaustedError
+	if assert.True(t, errors.As(err, &exhausted)) {
+		assert.Equal(t, uint64(3), exhausted.Attempts)
+		assert.Len(t, exhausted.Errors, 1)
+	}
+}
+
+func TestRetryContextNextFailure(t *testing.T) {
+	var count int
+	err, _ := runWithFakeClock(t, func(clock derr.Clock) error {
+		return derr.RetryContext(context.Background(), 2, func(ctx context.Context) error {
+			count++
+			if count > 1 {
+				return nil
+			}
+			return fmt.Errorf("I failed")
+		}, derr.RetryClock(clock))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestRetryContext_FataError(t *testing.T) {
+	var count int
+	err, _ := runWithFakeClock(t, func(clock derr.Clock) error {
+		return derr.RetryContext(context.Background(), 2, func(ctx context.Context) error {
+			count++
+			if count == 2 {
+				return derr.NewFatalError(fmt.Errorf("I failed with fatal error"))
+			}
+			return fmt.Errorf("I failed")
+		}, derr.RetryClock(clock))
+	})
+	assert.Error(t, err)
+	assert.EqualError(t, err, "I failed with fatal error")
+	assert.Equal(t, 2, count)
+}
+
+func TestRetryContext_AttemptTimeoutFakeClock(t *testing.T) {
+	clock := derrtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var causes []error
+	done := make(chan error, 1)
+	go func() {
+		done <- derr.RetryContext(context.Background(), 1, func(ctx context.Context) error {
+			if len(causes) > 0 {
+				return nil
+			}
+
+			<-ctx.Done()
+			causes = append(causes, context.Cause(ctx))
+			return ctx.Err()
+		}, derr.RetryClock(clock), derr.RetryAttemptTimeout(time.Hour), derr.RetryBackoff(derr.BackoffConstant, time.Second, 0))
+	}()
+
+	require.True(t, clock.WaitForTimers(1, 5*time.Second))
+	clock.Advance(time.Hour)
+	require.True(t, clock.WaitForTimers(1, 5*time.Second))
+	clock.Advance(time.Second)
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, []error{context.DeadlineExceeded}, causes)
+}
@@ -0,0 +1,327 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	retry "github.com/sethvargo/go-retry"
+	"go.uber.org/zap"
+)
+
+// BackoffStrategy is the curve followed by the delay between two attempts of [RetryContext].
+type BackoffStrategy int
+
+const (
+	// BackoffFibonacci waits `base`, `base`, `2*base`, `3*base`, `5*base`, etc. between attempts.
+	BackoffFibonacci BackoffStrategy = iota
+
+	// BackoffConstant always waits `base` between attempts.
+	BackoffConstant
+
+	// BackoffExponential waits `base`, `2*base`, `4*base`, `8*base`, etc. between attempts.
+	BackoffExponential
+
+	// BackoffDecorrelatedJitter waits a random delay between `base` and three times the previous
+	// delay between attempts, see https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.
+	BackoffDecorrelatedJitter
+)
+
+// Jitter is the randomization applied to the delay computed by the [BackoffStrategy].
+type Jitter int
+
+const (
+	// JitterNone uses the delay as computed by the [BackoffStrategy].
+	JitterNone Jitter = iota
+
+	// JitterFull waits a random delay between 0 and the computed delay.
+	JitterFull
+
+	// JitterEqual waits half the computed delay plus a random delay between 0 and the other half.
+	JitterEqual
+)
+
+// RetryOption configures the behavior of [Retry] and [RetryContext].
+type RetryOption func(*retryConfig)
+
+type retryConfig struct {
+	strategy       BackoffStrategy
+	base           time.Duration
+	cap            time.Duration
+	jitter         Jitter
+	maxElapsedTime time.Duration
+	attemptTimeout time.Duration
+	classifier     RetryClassifier
+	maxHintedDelay time.Duration
+	onRetry        []RetryHook
+	circuitBreaker *CircuitBreaker
+	budget         *RetryBudget
+	clock          Clock
+}
+
+func newRetryConfig(opts []RetryOption) *retryConfig {
+	config := &retryConfig{
+		strategy:   BackoffFibonacci,
+		base:       1 * time.Second,
+		cap:        5 * time.Second,
+		classifier: TransientClassifier,
+		clock:      RealClock,
+	}
+
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return config
+}
+
+// RetryBackoff configures the delay between attempts to follow `strategy` starting at `base`,
+// the delay never going over `cap`. A `cap` of 0 means the delay is not capped. This function
+// panics if `base` is not strictly positive.
+func RetryBackoff(strategy BackoffStrategy, base time.Duration, cap time.Duration) RetryOption {
+	if base <= 0 {
+		panic(fmt.Errorf("the 'base' argument must be strictly positive, got %s", base))
+	}
+
+	return func(config *retryConfig) {
+		config.strategy = strategy
+		config.base = base
+		config.cap = cap
+	}
+}
+
+// RetryJitter configures the randomization applied to the delay between attempts.
+func RetryJitter(jitter Jitter) RetryOption {
+	return func(config *retryConfig) {
+		config.jitter = jitter
+	}
+}
+
+// RetryMaxElapsedTime stops retrying once `max` has elapsed since the first attempt, the
+// delay before the last attempt being shortened so the total never goes over `max`.
+func RetryMaxElapsedTime(max time.Duration) RetryOption {
+	return func(config *retryConfig) {
+		config.maxElapsedTime = max
+	}
+}
+
+// RetryMaxHintedDelay caps the delay honored when a failed attempt asks for a minimum delay
+// before retrying (see [RetryDelayHint]). By default, those delays are capped to the backoff's
+// `cap`, 5s unless configured through [RetryBackoff], so a server asking for a longer delay
+// through `errdetails.RetryInfo` is retried sooner than it asked. Use a `max` longer than the
+// delays the server can ask for to honor them fully, a hinted delay is not capped when both
+// `max` and the backoff's `cap` are 0.
+func RetryMaxHintedDelay(max time.Duration) RetryOption {
+	return func(config *retryConfig) {
+		config.maxHintedDelay = max
+	}
+}
+
+// RetryHook is called by [RetryContext] after each failed attempt that is going to be retried,
+// `attempt` being the number (starting at 1) of the attempt that failed with `err` and `nextDelay`
+// the delay waited before the next one.
+type RetryHook func(attempt uint64, err error, nextDelay time.Duration)
+
+// RetryOnRetry registers `hook` to be called after each failed attempt that is going to be
+// retried. Can be used multiple times, the hooks being called in registration order.
+func RetryOnRetry(hook RetryHook) RetryOption {
+	return func(config *retryConfig) {
+		config.onRetry = append(config.onRetry, hook)
+	}
+}
+
+// RetryLogger logs each failed attempt that is going to be retried to `logger`.
+func RetryLogger(logger *zap.Logger) RetryOption {
+	return RetryOnRetry(func(attempt uint64, err error, nextDelay time.Duration) {
+		logger.Info("attempt failed, retrying", zap.Uint64("attempt", attempt), zap.Duration("next_delay", nextDelay), zap.Error(err))
+	})
+}
+
+// RetryCircuitBreaker makes each attempt go through `cb`, see [CircuitBreaker.Execute]. When
+// the circuit is open, the [CircuitOpenError] is returned right away without retrying.
+func RetryCircuitBreaker(cb *CircuitBreaker) RetryOption {
+	return func(config *retryConfig) {
+		config.circuitBreaker = cb
+	}
+}
+
+// RetryWithBudget makes retries consume from `budget`, shared by many callers, and successful
+// attempts deposit into it. When the budget is exhausted, a [RetryBudgetExhaustedError] is
+// returned right away instead of retrying.
+func RetryWithBudget(budget *RetryBudget) RetryOption {
+	return func(config *retryConfig) {
+		config.budget = budget
+	}
+}
+
+// RetryClock makes the retry loop measure time, wait between attempts and time out attempts (see
+// [RetryAttemptTimeout]) using `clock`, which is [RealClock] by default.
+func RetryClock(clock Clock) RetryOption {
+	return func(config *retryConfig) {
+		config.clock = clock
+	}
+}
+
+// RetryAttemptTimeout bounds each attempt to `timeout`, the `ctx` received by the retried
+// function being canceled once it elapses. An attempt that timed out is retried like any
+// other failed attempt.
+func RetryAttemptTimeout(timeout time.Duration) RetryOption {
+	return func(config *retryConfig) {
+		config.attemptTimeout = timeout
+	}
+}
+
+// RetryClassifier decides if an error returned by the function passed to [RetryContext] is
+// transient and should be retried. A [FatalError] is never retried, the classifier is not
+// even consulted for it.
+type RetryClassifier interface {
+	IsRetryable(err error) bool
+}
+
+// RetryClassifierFunc is an adapter to use an ordinary function as a [RetryClassifier].
+type RetryClassifierFunc func(err error) bool
+
+func (f RetryClassifierFunc) IsRetryable(err error) bool { return f(err) }
+
+// StrictRetryClassifier retries only the errors having a [RetryableError] in their causes chain.
+var StrictRetryClassifier RetryClassifier = RetryClassifierFunc(func(err error) bool {
+	return Find(err, isRetryableError) != nil
+})
+
+// RetryClassifiedBy configures which errors are retried, those not retried being returned right
+// away. By default, every error that is not a [FatalError] nor known to be permanent is retried,
+// see [TransientClassifier].
+func RetryClassifiedBy(classifier RetryClassifier) RetryOption {
+	return func(config *retryConfig) {
+		config.classifier = classifier
+	}
+}
+
+// RetryStrict configures the strict retry policy under which only the errors having a [RetryableError]
+// in their causes chain, or that one of `classifiers` reports as retryable, are retried, every other
+// error failing fast.
+func RetryStrict(classifiers ...RetryClassifier) RetryOption {
+	return RetryClassifiedBy(RetryClassifierFunc(func(err error) bool {
+		if StrictRetryClassifier.IsRetryable(err) {
+			return true
+		}
+
+		for _, classifier := range classifiers {
+			if classifier.IsRetryable(err) {
+				return true
+			}
+		}
+
+		return false
+	}))
+}
+
+func (c *retryConfig) attempt(ctx context.Context, f func(ctx context.Context) error) error {
+	if c.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withClockTimeout(ctx, c.clock, c.attemptTimeout)
+		defer cancel()
+	}
+
+	if c.circuitBreaker != nil {
+		return c.circuitBreaker.Execute(ctx, f)
+	}
+
+	return f(ctx)
+}
+
+func (c *retryConfig) capHintedDelay(delay time.Duration) time.Duration {
+	max := c.maxHintedDelay
+	if max <= 0 {
+		max = c.cap
+	}
+
+	if max > 0 && delay > max {
+		return max
+	}
+
+	return delay
+}
+
+func (c *retryConfig) backoff(maxretries uint64) retry.Backoff {
+	var b retry.Backoff
+	switch c.strategy {
+	case BackoffConstant:
+		b = retry.NewConstant(c.base)
+	case BackoffExponential:
+		b = retry.NewExponential(c.base)
+	case BackoffDecorrelatedJitter:
+		b = newDecorrelatedJitter(c.base, c.cap)
+	default:
+		b = retry.NewFibonacci(c.base)
+	}
+
+	if c.cap > 0 {
+		b = retry.WithCappedDuration(c.cap, b)
+	}
+
+	b = withJitter(c.jitter, b)
+	b = retry.WithMaxRetries(maxretries, b)
+
+	return b
+}
+
+func withJitter(jitter Jitter, next retry.Backoff) retry.Backoff {
+	if jitter == JitterNone {
+		return next
+	}
+
+	return retry.BackoffFunc(func() (time.Duration, bool) {
+		val, stop := next.Next()
+		if stop || val <= 0 {
+			return val, stop
+		}
+
+		if jitter == JitterEqual {
+			half := val / 2
+			return half + time.Duration(rand.Int63n(int64(val-half)+1)), false
+		}
+
+		return time.Duration(rand.Int63n(int64(val) + 1)), false
+	})
+}
+
+func newDecorrelatedJitter(base time.Duration, cap time.Duration) retry.Backoff {
+	var lock sync.Mutex
+	previous := base
+
+	return retry.BackoffFunc(func() (time.Duration, bool) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		upper := previous * 3
+		if upper <= base {
+			// Overflowed (or `base` is so small it's ineffective), restart from the bottom
+			upper = base + 1
+		}
+
+		val := base + time.Duration(rand.Int63n(int64(upper-base)))
+		if cap > 0 && val > cap {
+			val = cap
+		}
+
+		previous = val
+		return val, false
+	})
+}
@@ -2,57 +2,261 @@ package derr
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestRetryContext(t *testing.T) {
+func TestRetryContextCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := RetryContext(ctx, 2, func(ctx context.Context) error {
+		t.Fail()
+		return nil
+	})
+	assert.EqualError(t, err, "context canceled")
+}
+
+func TestRetryContext_Backoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []RetryOption
+		expected []time.Duration
+	}{
+		{"constant", []RetryOption{RetryBackoff(BackoffConstant, 10*time.Millisecond, 0)}, []time.Duration{10, 10, 10, 10, 10}},
+		{"exponential", []RetryOption{RetryBackoff(BackoffExponential, 10*time.Millisecond, 0)}, []time.Duration{10, 20, 40, 80, 160}},
+		{"exponential capped", []RetryOption{RetryBackoff(BackoffExponential, 10*time.Millisecond, 50*time.Millisecond)}, []time.Duration{10, 20, 40, 50, 50}},
+		{"fibonacci", []RetryOption{RetryBackoff(BackoffFibonacci, 10*time.Millisecond, 0)}, []time.Duration{10, 20, 30, 50, 80}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newRetryConfig(test.opts).backoff(5)
+
+			for _, expected := range test.expected {
+				delay, stop := b.Next()
+				assert.False(t, stop)
+				assert.Equal(t, expected*time.Millisecond, delay)
+			}
+
+			_, stop := b.Next()
+			assert.True(t, stop)
+		})
+	}
+}
+
+func TestRetryContext_BackoffJitter(t *testing.T) {
+	full := newRetryConfig([]RetryOption{RetryBackoff(BackoffConstant, 100*time.Millisecond, 0), RetryJitter(JitterFull)}).backoff(100)
+	equal := newRetryConfig([]RetryOption{RetryBackoff(BackoffConstant, 100*time.Millisecond, 0), RetryJitter(JitterEqual)}).backoff(100)
+	decorrelated := newRetryConfig([]RetryOption{RetryBackoff(BackoffDecorrelatedJitter, 10*time.Millisecond, 200*time.Millisecond)}).backoff(100)
+
+	for i := 0; i < 100; i++ {
+		delay, _ := full.Next()
+		assert.True(t, delay >= 0 && delay <= 100*time.Millisecond, "full jitter delay %s out of bounds", delay)
+
+		delay, _ = equal.Next()
+		assert.True(t, delay >= 50*time.Millisecond && delay <= 100*time.Millisecond, "equal jitter delay %s out of bounds", delay)
+
+		delay, _ = decorrelated.Next()
+		assert.True(t, delay >= 10*time.Millisecond && delay <= 200*time.Millisecond, "decorrelated jitter delay %s out of bounds", delay)
+	}
+}
+
+func TestRetryContext_MaxElapsedTime(t *testing.T) {
 	var count int
-	err := RetryContext(context.Background(), 2, func(ctx context.Context) error {
+	start := time.Now()
+	err := RetryContext(context.Background(), 1000, func(ctx context.Context) error {
 		count++
 		return fmt.Errorf("I failed")
-	})
-	assert.Error(t, err)
-	assert.EqualError(t, err, "I failed")
+	}, RetryBackoff(BackoffConstant, 10*time.Millisecond, 0), RetryMaxElapsedTime(55*time.Millisecond))
+
+	assert.EqualError(t, errors.Unwrap(err), "I failed")
+	assert.True(t, count > 1 && count < 10, "unexpected attempt count %d", count)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRetryContext_AttemptTimeout(t *testing.T) {
+	var count int
+	err := RetryContext(context.Background(), 3, func(ctx context.Context) error {
+		count++
+		if count < 3 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		return nil
+	}, RetryBackoff(BackoffConstant, time.Millisecond, 0), RetryAttemptTimeout(10*time.Millisecond))
+
+	assert.NoError(t, err)
 	assert.Equal(t, 3, count)
 }
 
-func TestRetryContextNextFailure(t *testing.T) {
+func TestRetryContext_Strict(t *testing.T) {
+	fast := RetryBackoff(BackoffConstant, time.Millisecond, 0)
+
 	var count int
 	err := RetryContext(context.Background(), 2, func(ctx context.Context) error {
 		count++
-		if count > 1 {
-			return nil
-		}
 		return fmt.Errorf("I failed")
-	})
-	assert.NoError(t, err)
-	assert.Equal(t, 2, count)
+	}, fast, RetryStrict())
+	assert.EqualError(t, err, "I failed")
+	assert.Equal(t, 1, count)
+
+	count = 0
+	err = RetryContext(context.Background(), 2, func(ctx context.Context) error {
+		count++
+		return fmt.Errorf("wrapped: %w", NewRetryableError(fmt.Errorf("I failed")))
+	}, fast, RetryStrict())
+	assert.EqualError(t, errors.Unwrap(err), "wrapped: I failed (retryable)")
+	assert.Equal(t, 3, count)
+
+	errTransient := fmt.Errorf("transient")
+	count = 0
+	err = RetryContext(context.Background(), 2, func(ctx context.Context) error {
+		count++
+		return errTransient
+	}, fast, RetryStrict(RetryClassifierFunc(func(err error) bool { return Is(err, errTransient) })))
+	assert.Equal(t, errTransient, errors.Unwrap(err))
+	assert.Equal(t, 3, count)
 }
 
-func TestRetryContextCanceledContext(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
+func TestRetryContext_HintedDelay(t *testing.T) {
+	fast := RetryBackoff(BackoffConstant, time.Millisecond, 0)
 
-	err := RetryContext(ctx, 2, func(ctx context.Context) error {
-		t.Fail()
-		return nil
-	})
-	assert.EqualError(t, err, "context canceled")
+	tests := []struct {
+		name     string
+		err      error
+		opts     []RetryOption
+		expected time.Duration
+	}{
+		{"retryable error", NewRetryableErrorWithDelay(errors.New("test"), 50*time.Millisecond), nil, 50 * time.Millisecond},
+		{"wrapped error response", Wrap(&ErrorResponse{Status: 429, RetryDelay: 50 * time.Millisecond}, "test"), nil, 50 * time.Millisecond},
+		{"grpc retry info", (&ErrorResponse{Status: 503, RetryDelay: 50 * time.Millisecond}).GRPCStatus().Err(), nil, 50 * time.Millisecond},
+		{"capped", NewRetryableErrorWithDelay(errors.New("test"), time.Hour), []RetryOption{RetryMaxHintedDelay(50 * time.Millisecond)}, 50 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var count int
+			start := time.Now()
+			err := RetryContext(context.Background(), 1, func(ctx context.Context) error {
+				count++
+				return test.err
+			}, append([]RetryOption{fast}, test.opts...)...)
+
+			elapsed := time.Since(start)
+			assert.Equal(t, test.err, errors.Unwrap(err))
+			assert.Equal(t, 2, count)
+			assert.True(t, elapsed >= test.expected && elapsed < test.expected+time.Second, "unexpected elapsed time %s", elapsed)
+		})
+	}
+}
+
+func TestRetryDelayHint(t *testing.T) {
+	assert.Equal(t, time.Duration(0), RetryDelayHint(nil))
+	assert.Equal(t, time.Duration(0), RetryDelayHint(errors.New("test")))
+	assert.Equal(t, time.Duration(0), RetryDelayHint(NewRetryableError(errors.New("test"))))
+	assert.Equal(t, 3*time.Second, RetryDelayHint(fmt.Errorf("wrapped: %w", NewRetryableErrorWithDelay(errors.New("test"), 3*time.Second))))
+}
+
+func TestRetryContext_Observability(t *testing.T) {
+	type hookCall struct {
+		attempt   uint64
+		err       string
+		nextDelay time.Duration
+	}
+
+	var attempts []uint64
+	var calls []hookCall
+	err := RetryContext(context.Background(), 3, func(ctx context.Context) error {
+		attempt := RetryAttemptFromContext(ctx)
+		attempts = append(attempts, attempt)
+
+		return fmt.Errorf("failure #%d", (attempt+1)/2)
+	}, RetryBackoff(BackoffConstant, time.Millisecond, 0), RetryOnRetry(func(attempt uint64, err error, nextDelay time.Duration) {
+		calls = append(calls, hookCall{attempt, err.Error(), nextDelay})
+	}))
+
+	assert.Equal(t, []uint64{1, 2, 3, 4}, attempts)
+	assert.Equal(t, []hookCall{
+		{1, "failure #1", time.Millisecond},
+		{2, "failure #1", time.Millisecond},
+		{3, "failure #2", time.Millisecond},
+	}, calls)
+
+	var exhausted *RetriesExhaustedError
+	require.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, uint64(4), exhausted.Attempts)
+	assert.True(t, exhausted.Elapsed >= 3*time.Millisecond)
+	assert.Equal(t, []error{fmt.Errorf("failure #1"), fmt.Errorf("failure #2")}, exhausted.Errors)
+	assert.EqualError(t, exhausted.Last(), "failure #2")
+	assert.Regexp(t, `^retries exhausted after 4 attempts in [0-9.]+m?s, 2 distinct errors: failure #2$`, err.Error())
+
+	assert.Equal(t, uint64(0), RetryAttemptFromContext(context.Background()))
 }
 
-func TestRetryContext_FataError(t *testing.T) {
+func TestRetryValue(t *testing.T) {
+	fast := RetryBackoff(BackoffConstant, time.Millisecond, 0)
+
+	value, err := RetryValue(context.Background(), 3, func(ctx context.Context) (string, error) {
+		if RetryAttemptFromContext(ctx) < 3 {
+			return "partial", fmt.Errorf("I failed")
+		}
+
+		return "complete", nil
+	}, fast)
+	assert.NoError(t, err)
+	assert.Equal(t, "complete", value)
+
+	value, err = RetryValue(context.Background(), 2, func(ctx context.Context) (string, error) {
+		return "partial", fmt.Errorf("I failed")
+	}, fast)
+	assert.EqualError(t, errors.Unwrap(err), "I failed")
+	assert.Equal(t, "", value)
+
 	var count int
-	err := RetryContext(context.Background(), 2, func(ctx context.Context) error {
+	pointer, err := RetryValue(context.Background(), 2, func(ctx context.Context) (*int, error) {
 		count++
 		if count == 2 {
-			return NewFatalError(fmt.Errorf("I failed with fatal error"))
+			return &count, NewFatalError(fmt.Errorf("I failed with fatal error"))
 		}
-		return fmt.Errorf("I failed")
-	})
-	assert.Error(t, err)
+
+		return &count, fmt.Errorf("I failed")
+	}, fast)
 	assert.EqualError(t, err, "I failed with fatal error")
+	assert.Nil(t, pointer)
 	assert.Equal(t, 2, count)
 }
+
+func TestRetryValue_ConcurrentCallers(t *testing.T) {
+	// Must be run with `-race` to be meaningful, each caller gets its own result without
+	// sharing any captured variable.
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			results[i], _ = RetryValue(context.Background(), 2, func(ctx context.Context) (int, error) {
+				if RetryAttemptFromContext(ctx) == 1 {
+					return -1, fmt.Errorf("I failed")
+				}
+
+				return i, nil
+			}, RetryBackoff(BackoffConstant, time.Millisecond, 0))
+		}(i)
+	}
+
+	wg.Wait()
+	for i, result := range results {
+		assert.Equal(t, i, result)
+	}
+}
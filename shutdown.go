@@ -0,0 +1,386 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The default shutdown phases, in the order they run, see [ShutdownPhases].
+const (
+	// ShutdownPhaseStopIngress is where the servers stop accepting new requests
+	ShutdownPhaseStopIngress = "stop_ingress"
+
+	// ShutdownPhaseDrain is where the requests and streams in progress are drained
+	ShutdownPhaseDrain = "drain"
+
+	// ShutdownPhaseFlush is where the buffered data is flushed to the sinks
+	ShutdownPhaseFlush = "flush"
+
+	// ShutdownPhaseClose is where the connections (databases, clients, etc.) are closed
+	ShutdownPhaseClose = "close"
+)
+
+// ShutdownHook tears down a component, it should return once `ctx` is done. A panicking hook
+// fails with a [PanicError] without affecting the other hooks.
+type ShutdownHook func(ctx context.Context) error
+
+// ShutdownOption configures a [Shutdown] created through [NewShutdown].
+type ShutdownOption func(*Shutdown)
+
+// ShutdownPhases configures the phases, in the order they run. Defaults to [ShutdownPhaseStopIngress],
+// [ShutdownPhaseDrain], [ShutdownPhaseFlush] and [ShutdownPhaseClose]. The hooks registered in
+// another phase run after those, phases ordered by first registration.
+func ShutdownPhases(phases ...string) ShutdownOption {
+	return func(s *Shutdown) {
+		s.phases = phases
+	}
+}
+
+// ShutdownTimeout bounds the time all the hooks can take, 30s by default. Once it elapsed, the
+// hooks still running are abandoned and the following phases are skipped.
+func ShutdownTimeout(timeout time.Duration) ShutdownOption {
+	return func(s *Shutdown) {
+		s.timeout = timeout
+	}
+}
+
+// ShutdownHookTimeout bounds the time each hook can take, 10s by default, see [ShutdownHookWithTimeout]
+// to override it for a single hook.
+func ShutdownHookTimeout(timeout time.Duration) ShutdownOption {
+	return func(s *Shutdown) {
+		s.hookTimeout = timeout
+	}
+}
+
+// ShutdownSlowHookThreshold configures after how long a hook still running is logged as slow, 5s by default.
+func ShutdownSlowHookThreshold(threshold time.Duration) ShutdownOption {
+	return func(s *Shutdown) {
+		s.slowThreshold = threshold
+	}
+}
+
+// ShutdownLogger configures the logger used to report the progress of the shutdown.
+func ShutdownLogger(logger *zap.Logger) ShutdownOption {
+	return func(s *Shutdown) {
+		s.logger = logger
+	}
+}
+
+// ShutdownClock makes the timeouts and the slow hook threshold measured using `clock`, which is
+// [RealClock] by default.
+func ShutdownClock(clock Clock) ShutdownOption {
+	return func(s *Shutdown) {
+		s.clock = clock
+	}
+}
+
+// ShutdownHookOption configures a single hook registered through [Shutdown.Register].
+type ShutdownHookOption func(*shutdownHook)
+
+// ShutdownHookWithTimeout overrides the [ShutdownHookTimeout] for this hook.
+func ShutdownHookWithTimeout(timeout time.Duration) ShutdownHookOption {
+	return func(h *shutdownHook) {
+		h.timeout = timeout
+	}
+}
+
+type shutdownHook struct {
+	phase   string
+	name    string
+	hook    ShutdownHook
+	timeout time.Duration
+}
+
+// Shutdown is a registry of the hooks tearing down the components of a service. The hooks are
+// grouped in phases running one after the other, the hooks of a phase running concurrently.
+// It's safe for concurrent use.
+type Shutdown struct {
+	phases        []string
+	timeout       time.Duration
+	hookTimeout   time.Duration
+	slowThreshold time.Duration
+	logger        *zap.Logger
+	clock         Clock
+
+	lock  sync.Mutex
+	hooks []*shutdownHook
+
+	once sync.Once
+	err  error
+}
+
+// NewShutdown creates a new empty [Shutdown] registry.
+func NewShutdown(opts ...ShutdownOption) *Shutdown {
+	s := &Shutdown{
+		phases:        []string{ShutdownPhaseStopIngress, ShutdownPhaseDrain, ShutdownPhaseFlush, ShutdownPhaseClose},
+		timeout:       30 * time.Second,
+		hookTimeout:   10 * time.Second,
+		slowThreshold: 5 * time.Second,
+		logger:        zlog,
+		clock:         RealClock,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Register adds `hook` to `phase`, `name` identifying it in the logs and errors.
+func (s *Shutdown) Register(phase string, name string, hook ShutdownHook, opts ...ShutdownHookOption) {
+	h := &shutdownHook{phase: phase, name: name, hook: hook, timeout: s.hookTimeout}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.hooks = append(s.hooks, h)
+}
+
+// Run runs the registered hooks phase by phase and returns a [ShutdownError] if any of them failed
+// or did not complete in time. The hooks only run once, the following calls wait for the first
+// one to complete and return the same result.
+func (s *Shutdown) Run(ctx context.Context) error {
+	s.once.Do(func() {
+		s.err = s.run(ctx)
+	})
+
+	return s.err
+}
+
+func (s *Shutdown) run(ctx context.Context) error {
+	ctx, cancel := withClockTimeout(ctx, s.clock, s.timeout)
+	defer cancel()
+
+	shutdownErr := &ShutdownError{}
+	for _, phase := range s.orderedPhases() {
+		hooks := s.phaseHooks(phase)
+		if len(hooks) == 0 {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			for _, h := range hooks {
+				shutdownErr.Errors = append(shutdownErr.Errors, &ShutdownHookError{Phase: h.phase, Name: h.name, Err: fmt.Errorf("skipped: %w", context.Cause(ctx))})
+			}
+			continue
+		}
+
+		s.logger.Info("running shutdown phase", zap.String("phase", phase), zap.Int("hooks", len(hooks)))
+
+		errs := make([]error, len(hooks))
+		wg := sync.WaitGroup{}
+		for i, h := range hooks {
+			wg.Add(1)
+			go func(i int, h *shutdownHook) {
+				defer wg.Done()
+				errs[i] = s.runHook(ctx, h)
+			}(i, h)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				shutdownErr.Errors = append(shutdownErr.Errors, &ShutdownHookError{Phase: phase, Name: hooks[i].name, Err: err})
+			}
+		}
+	}
+
+	if context.Cause(ctx) == context.DeadlineExceeded {
+		shutdownErr.TimedOut = true
+	}
+
+	if len(shutdownErr.Errors) == 0 && !shutdownErr.TimedOut {
+		s.logger.Info("shutdown completed")
+		return nil
+	}
+
+	return shutdownErr
+}
+
+func (s *Shutdown) runHook(ctx context.Context, h *shutdownHook) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withClockTimeout(ctx, s.clock, h.timeout)
+		defer cancel()
+	}
+
+	logger := s.logger.With(zap.String("phase", h.phase), zap.String("hook", h.name))
+	start := s.clock.Now()
+
+	slow := s.clock.AfterFunc(s.slowThreshold, func() {
+		logger.Warn("shutdown hook is slow, still running", zap.Duration("elapsed", s.clock.Now().Sub(start)))
+	})
+	defer slow.Stop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runRecovered(ctx, h.hook)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// The hook did not honor the cancellation, we abandon it
+		err = fmt.Errorf("did not complete in time: %w", context.Cause(ctx))
+	}
+
+	if err != nil {
+		logger.Warn("shutdown hook failed", zap.Duration("elapsed", s.clock.Now().Sub(start)), zap.Error(err))
+		return err
+	}
+
+	logger.Debug("shutdown hook completed", zap.Duration("elapsed", s.clock.Now().Sub(start)))
+	return nil
+}
+
+func (s *Shutdown) orderedPhases() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	phases := append([]string(nil), s.phases...)
+	for _, h := range s.hooks {
+		if !containsString(phases, h.phase) {
+			phases = append(phases, h.phase)
+		}
+	}
+
+	return phases
+}
+
+func (s *Shutdown) phaseHooks(phase string) (out []*shutdownHook) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, h := range s.hooks {
+		if h.phase == phase {
+			out = append(out, h)
+		}
+	}
+
+	return out
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ShutdownError is returned by [Shutdown.Run] when some hooks failed or did not complete in time.
+type ShutdownError struct {
+	// Errors are the errors of the hooks that failed, were abandoned or skipped, in phase order
+	Errors []*ShutdownHookError
+
+	// TimedOut is true when the [ShutdownTimeout] (or the deadline of the context given to
+	// [Shutdown.Run]) elapsed before all the hooks completed, a canceled context is not a timeout
+	TimedOut bool
+}
+
+// ExitCode is the suggested exit code of the process, `124` if the shutdown timed out (like
+// the `timeout` command does), `1` otherwise.
+func (e *ShutdownError) ExitCode() int {
+	if e.TimedOut {
+		return 124
+	}
+
+	return 1
+}
+
+func (e *ShutdownError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+func (e *ShutdownError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+
+	prefix := "shutdown failed"
+	if e.TimedOut {
+		prefix = "shutdown timed out"
+	}
+
+	if len(messages) == 0 {
+		return prefix
+	}
+
+	return fmt.Sprintf("%s: %s", prefix, strings.Join(messages, "; "))
+}
+
+// ShutdownHookError is the error of a single hook, see [ShutdownError].
+type ShutdownHookError struct {
+	Phase string
+	Name  string
+	Err   error
+}
+
+func (e *ShutdownHookError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ShutdownHookError) Error() string {
+	return fmt.Sprintf("%s/%s: %s", e.Phase, e.Name, e.Err)
+}
+
+// ShutdownExitCode returns the suggested exit code for the result of [Shutdown.Run], `0` when
+// `err` is `nil`.
+func ShutdownExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var shutdownErr *ShutdownError
+	if errors.As(err, &shutdownErr) {
+		return shutdownErr.ExitCode()
+	}
+
+	return 1
+}
+
+var defaultShutdown = NewShutdown()
+
+// RegisterShutdownHook adds `hook` to `phase` of the process wide [Shutdown] registry, see [Shutdown.Register].
+func RegisterShutdownHook(phase string, name string, hook ShutdownHook, opts ...ShutdownHookOption) {
+	defaultShutdown.Register(phase, name, hook, opts...)
+}
+
+// RunShutdownHooks runs the hooks of the process wide [Shutdown] registry, see [Shutdown.Run].
+// Typically called once the channel returned by [SetupSignalHandler] received a signal.
+func RunShutdownHooks(ctx context.Context) error {
+	return defaultShutdown.Run(ctx)
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/derr"
+	"github.com/streamingfast/derr/derrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown_FakeClock(t *testing.T) {
+	clock := derrtest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := derr.NewShutdown(derr.ShutdownClock(clock), derr.ShutdownTimeout(time.Hour), derr.ShutdownHookTimeout(time.Minute))
+
+	release := make(chan struct{})
+	defer close(release)
+	s.Register(derr.ShutdownPhaseDrain, "stuck", func(ctx context.Context) error { <-release; return nil })
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background()) }()
+
+	// The global timeout, the hook timeout and the slow hook threshold
+	require.True(t, clock.WaitForTimers(3, 5*time.Second))
+	clock.Advance(time.Minute)
+
+	err := <-done
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, derr.ShutdownExitCode(err))
+	assert.EqualError(t, err, "shutdown failed: drain/stuck: did not complete in time: context deadline exceeded")
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown_PhasesInOrder(t *testing.T) {
+	s := NewShutdown()
+
+	lock := sync.Mutex{}
+	var calls []string
+	record := func(name string) ShutdownHook {
+		return func(ctx context.Context) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	s.Register("custom", "custom", record("custom"))
+	s.Register(ShutdownPhaseClose, "db", record("db"))
+	s.Register(ShutdownPhaseFlush, "sink", record("sink"))
+	s.Register(ShutdownPhaseStopIngress, "http", record("http"))
+	s.Register(ShutdownPhaseStopIngress, "grpc", record("grpc"))
+
+	require.NoError(t, s.Run(context.Background()))
+	assert.ElementsMatch(t, []string{"http", "grpc"}, calls[0:2])
+	assert.Equal(t, []string{"sink", "db", "custom"}, calls[2:])
+
+	require.NoError(t, s.Run(context.Background()))
+	assert.Len(t, calls, 5, "hooks should run only once")
+	assert.Equal(t, 0, ShutdownExitCode(nil))
+}
+
+func TestShutdown_ParallelInsidePhase(t *testing.T) {
+	s := NewShutdown()
+
+	started := make(chan struct{})
+	s.Register(ShutdownPhaseDrain, "first", func(ctx context.Context) error {
+		<-started
+		return nil
+	})
+	s.Register(ShutdownPhaseDrain, "second", func(ctx context.Context) error {
+		close(started)
+		return nil
+	})
+
+	assert.NoError(t, s.Run(context.Background()))
+}
+
+func TestShutdown_Failures(t *testing.T) {
+	s := NewShutdown(ShutdownHookTimeout(20 * time.Millisecond))
+
+	s.Register(ShutdownPhaseDrain, "failing", func(ctx context.Context) error { return errors.New("boom") })
+	s.Register(ShutdownPhaseDrain, "stuck", func(ctx context.Context) error { select {} })
+	s.Register(ShutdownPhaseFlush, "patient", func(ctx context.Context) error {
+		time.Sleep(50 * time.Millisecond)
+		return ctx.Err()
+	}, ShutdownHookWithTimeout(time.Second))
+
+	err := s.Run(context.Background())
+
+	var shutdownErr *ShutdownError
+	require.True(t, errors.As(err, &shutdownErr))
+	assert.False(t, shutdownErr.TimedOut)
+	assert.Equal(t, 1, ShutdownExitCode(err))
+	assert.Equal(t, "shutdown failed: drain/failing: boom; drain/stuck: did not complete in time: context deadline exceeded", err.Error())
+}
+
+func TestShutdown_GlobalTimeout(t *testing.T) {
+	s := NewShutdown(ShutdownTimeout(20 * time.Millisecond))
+
+	closed := false
+	s.Register(ShutdownPhaseDrain, "long", func(ctx context.Context) error { select {} })
+	s.Register(ShutdownPhaseClose, "db", func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	err := s.Run(context.Background())
+	assert.False(t, closed)
+	assert.Equal(t, 124, ShutdownExitCode(err))
+	assert.Equal(t, "shutdown timed out: drain/long: did not complete in time: context deadline exceeded; close/db: skipped: context deadline exceeded", err.Error())
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestShutdown_PanickingHook(t *testing.T) {
+	s := NewShutdown()
+
+	closed := false
+	s.Register(ShutdownPhaseFlush, "buffer", func(ctx context.Context) error { panic("boom") })
+	s.Register(ShutdownPhaseClose, "db", func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	err := s.Run(context.Background())
+	assert.True(t, closed)
+	assert.Equal(t, 1, ShutdownExitCode(err))
+
+	var panicErr *PanicError
+	require.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, "boom", panicErr.Value)
+}
+
+func TestShutdown_ParentCanceled(t *testing.T) {
+	s := NewShutdown()
+	s.Register(ShutdownPhaseClose, "db", func(ctx context.Context) error { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.Run(ctx)
+	assert.Equal(t, 1, ShutdownExitCode(err))
+	assert.EqualError(t, err, "shutdown failed: close/db: skipped: context canceled")
+}
@@ -15,62 +15,330 @@
 package derr
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
-	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
-var isShuttingDown *atomic.Bool
+var defaultShutdownManager = NewShutdownManager()
 
-func init() {
-	isShuttingDown = atomic.NewBool(false)
+// IsShuttingDown returns whether the process wide [ShutdownManager] received a termination signal.
+func IsShuttingDown() bool {
+	return defaultShutdownManager.IsShuttingDown()
 }
 
-func IsShuttingDown() bool {
-	return isShuttingDown.Load()
+// SignalOption configures the signal handling, see [NewShutdownManager], [SetupSignalHandler] and [SignalContext].
+type SignalOption func(*signalConfig)
+
+type signalConfig struct {
+	clock          Clock
+	signals        []os.Signal
+	gracefulDelay  time.Duration
+	forceKillCount int
+	logger         *zap.Logger
+	exit           func(code int)
+
+	diagnosticsSignal    os.Signal
+	diagnosticsDirectory string
+}
+
+func (c signalConfig) with(opts []SignalOption) *signalConfig {
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// SignalClock makes the graceful shutdown delay measured using `clock`, which is [RealClock] by default.
+func SignalClock(clock Clock) SignalOption {
+	return func(config *signalConfig) {
+		config.clock = clock
+	}
+}
+
+// SignalNotify configures the signals triggering the shutdown, `SIGINT` and `SIGTERM` by default. Without
+// any signal, the shutdown can only be triggered from within the process, see [ShutdownManager.RequestShutdown].
+func SignalNotify(signals ...os.Signal) SignalOption {
+	return func(config *signalConfig) {
+		config.signals = signals
+	}
+}
+
+// SignalForceKillCount configures how many signals can be received before the process is forcefully
+// killed, the process being killed on the next one, 3 by default.
+func SignalForceKillCount(count int) SignalOption {
+	return func(config *signalConfig) {
+		config.forceKillCount = count
+	}
+}
+
+// SignalLogger configures the logger used to report the signals received.
+func SignalLogger(logger *zap.Logger) SignalOption {
+	return func(config *signalConfig) {
+		config.logger = logger
+	}
+}
+
+// SignalExitFunc configures the function called to forcefully kill the process, [Exit] by default.
+func SignalExitFunc(exit func(code int)) SignalOption {
+	return func(config *signalConfig) {
+		config.exit = exit
+	}
+}
+
+// SignalGracefulDelay configures the delay between the first signal and the shutdown, no delay by
+// default. The package level [SetupSignalHandler] receives it as an argument instead.
+func SignalGracefulDelay(delay time.Duration) SignalOption {
+	return func(config *signalConfig) {
+		config.gracefulDelay = delay
+	}
+}
+
+// SignalDiagnostics makes receiving `signal` (typically `syscall.SIGUSR1`) dump diagnostics about the
+// process (see [WriteDiagnostics]) without affecting it otherwise. The dump is written to a new file in
+// `directory`, or logged when `directory` is empty.
+func SignalDiagnostics(signal os.Signal, directory string) SignalOption {
+	return func(config *signalConfig) {
+		config.diagnosticsSignal = signal
+		config.diagnosticsDirectory = directory
+	}
+}
+
+// SignalError is the cause (see `context.Cause`) of the contexts returned by [SignalContext]
+// once they are canceled because a signal was received.
+type SignalError struct {
+	// Signal is the signal received
+	Signal os.Signal
+
+	reason string
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("received %s signal, %s", e.Signal, e.reason)
+}
+
+// ExitCode returns [ExitInterrupted] for `SIGINT` and 128 plus the signal number otherwise, by
+// shell convention, see [ExitCode]. It's found in the causes chain of the errors derived from
+// the cause of the contexts returned by [SignalContext].
+func (e *SignalError) ExitCode() int {
+	if e.Signal == syscall.SIGINT {
+		return ExitInterrupted
+	}
+
+	if signal, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(signal)
+	}
+
+	return ExitFailure
+}
+
+// ShutdownManager handles the termination signals received by the process and tracks whether the
+// process is shutting down. Most services use the process wide one through the package level
+// [SetupSignalHandler], [SignalContext] and [IsShuttingDown] functions, a dedicated instance
+// giving an independent lifecycle to a library embedded in a binary, or to a test.
+type ShutdownManager struct {
+	config    *signalConfig
+	lifecycle *Lifecycle
+	handlers  *signalHandlers
+}
+
+// signalHandlers are the channels of the active signal handlers, see [ShutdownManager.RequestShutdown]
+type signalHandlers struct {
+	lock     sync.Mutex
+	channels map[chan os.Signal]bool
+
+	// received is the first termination signal received by any of the handlers, `nil` until then
+	received os.Signal
+}
+
+// NewShutdownManager creates a new [ShutdownManager] listening to `SIGINT` and `SIGTERM` with no
+// graceful delay by default, see the `Signal...` options to configure it.
+func NewShutdownManager(opts ...SignalOption) *ShutdownManager {
+	config := signalConfig{
+		clock:          RealClock,
+		signals:        []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+		forceKillCount: 3,
+		logger:         zlog,
+		exit:           Exit,
+	}
+
+	manager := &ShutdownManager{config: config.with(opts), handlers: &signalHandlers{channels: map[chan os.Signal]bool{}}}
+	manager.lifecycle = NewLifecycle(LifecycleClock(manager.config.clock))
+
+	return manager
+}
+
+// withOptions returns a copy of the manager sharing its shutting down state, configured with `opts` on top.
+func (m *ShutdownManager) withOptions(opts []SignalOption) *ShutdownManager {
+	return &ShutdownManager{config: m.config.with(opts), lifecycle: m.lifecycle, handlers: m.handlers}
+}
+
+// RequestShutdown makes the active signal handlers (see [ShutdownManager.SetupSignalHandler] and
+// [ShutdownManager.SignalContext]) behave as if the process received a `SIGTERM`, starting a graceful
+// shutdown from within the process. Returns `false` if no signal handler is active.
+func (m *ShutdownManager) RequestShutdown() bool {
+	m.handlers.lock.Lock()
+	defer m.handlers.lock.Unlock()
+
+	for channel := range m.handlers.channels {
+		select {
+		case channel <- syscall.SIGTERM:
+		default:
+		}
+	}
+
+	return len(m.handlers.channels) > 0
+}
+
+// receivedSignal returns the first termination signal received by the manager, `nil` if none was.
+func (m *ShutdownManager) receivedSignal() os.Signal {
+	m.handlers.lock.Lock()
+	defer m.handlers.lock.Unlock()
+
+	return m.handlers.received
+}
+
+// IsShuttingDown returns whether a termination signal was received, or more generally whether
+// the [Lifecycle] is draining or in a later state.
+func (m *ShutdownManager) IsShuttingDown() bool {
+	return m.lifecycle.State() >= LifecycleDraining
+}
+
+// Lifecycle returns the [Lifecycle] driven by the manager, entering [LifecycleDraining] on the first
+// termination signal and [LifecycleStopping] once the graceful delay elapsed or on the next signal.
+func (m *ShutdownManager) Lifecycle() *Lifecycle {
+	return m.lifecycle
 }
 
 // this is a graceful delay to allow residual traffic sent by the load balancer to be processed
 // without returning 500. Once the delay has passed then the service can be shutdown
-func SetupSignalHandler(gracefulShutdownDelay time.Duration) <-chan os.Signal {
+func SetupSignalHandler(gracefulShutdownDelay time.Duration, opts ...SignalOption) <-chan os.Signal {
+	return defaultShutdownManager.withOptions(append(opts, SignalGracefulDelay(gracefulShutdownDelay))).SetupSignalHandler()
+}
+
+// SetupSignalHandler returns a channel receiving the first signal once the graceful delay elapsed,
+// then each following signal right away.
+func (m *ShutdownManager) SetupSignalHandler() <-chan os.Signal {
 	outgoingSignals := make(chan os.Signal, 10)
-	signals := make(chan os.Signal)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	m.handleSignals(nil, func(s os.Signal) {}, func(s os.Signal, reason string) {
+		outgoingSignals <- s
+	})
+
+	return outgoingSignals
+}
+
+// SignalContext is [ShutdownManager.SignalContext] on the process wide [ShutdownManager].
+func SignalContext(parent context.Context, opts ...SignalOption) (ctx context.Context, draining context.Context) {
+	return defaultShutdownManager.withOptions(opts).SignalContext(parent)
+}
+
+// SignalContext returns a context canceled once the graceful shutdown delay (see [SignalGracefulDelay])
+// elapsed after the first signal was received, or right away on the second signal, its cause being
+// a [SignalError]. The `draining` context is canceled as soon as the first signal is received, when
+// the service should stop accepting new work while still finishing the work in progress.
+//
+// Both contexts are children of `parent`, the signals stop being listened to once `parent` is done.
+// Receiving the signal more than [SignalForceKillCount] times forcefully kills the process.
+func (m *ShutdownManager) SignalContext(parent context.Context) (ctx context.Context, draining context.Context) {
+	ctx, cancel := context.WithCancelCause(parent)
+	draining, cancelDraining := context.WithCancelCause(parent)
+
+	m.handleSignals(parent.Done(), func(s os.Signal) {
+		cancelDraining(&SignalError{Signal: s, reason: "draining"})
+	}, func(s os.Signal, reason string) {
+		cancelDraining(&SignalError{Signal: s, reason: "draining"})
+		cancel(&SignalError{Signal: s, reason: reason})
+	})
+
+	return ctx, draining
+}
+
+// handleSignals listens to the configured signals until `done` is closed, `onDraining` being called
+// on the first signal and `onShutdown` once the graceful delay elapsed, then on each following signal.
+func (m *ShutdownManager) handleSignals(done <-chan struct{}, onDraining func(s os.Signal), onShutdown func(s os.Signal, reason string)) {
+	config := m.config
+
+	signals := make(chan os.Signal, 1)
+	if len(config.signals) > 0 {
+		// Without any signal, `signal.Notify` would relay all of them
+		signal.Notify(signals, config.signals...)
+	}
+
+	m.handlers.lock.Lock()
+	m.handlers.channels[signals] = true
+	m.handlers.lock.Unlock()
+
+	var diagnostics chan os.Signal
+	if config.diagnosticsSignal != nil {
+		diagnostics = make(chan os.Signal, 1)
+		signal.Notify(diagnostics, config.diagnosticsSignal)
+	}
 
 	seen := 0
 
 	go func() {
+		defer func() {
+			signal.Stop(signals)
+
+			m.handlers.lock.Lock()
+			delete(m.handlers.channels, signals)
+			m.handlers.lock.Unlock()
+		}()
+		if diagnostics != nil {
+			defer signal.Stop(diagnostics)
+		}
+
 		for {
-			s := <-signals
-			switch s {
-			case syscall.SIGTERM, syscall.SIGINT:
-				seen++
-
-				if seen > 3 {
-					zlog.Info("Received termination signal 3 times: Forcing kill")
-					zlog.Sync()
-					os.Exit(1)
-				}
+			var s os.Signal
+			select {
+			case <-done:
+				return
+			case <-diagnostics:
+				// A large dump must not delay the handling of a termination signal
+				go dumpDiagnostics(config.logger, config.diagnosticsDirectory)
+				continue
+			case s = <-signals:
+			}
+
+			seen++
+
+			if seen > config.forceKillCount {
+				config.logger.Info(fmt.Sprintf("Received termination signal %d times: Forcing kill", config.forceKillCount))
+				config.logger.Sync()
+				config.exit(1)
+				return
+			}
 
-				if !IsShuttingDown() {
-					zlog.Info("Received termination signal... Ctrl+C multiple times to force kill", zap.String("signal", s.String()))
-					v := true
-					isShuttingDown.Store(v)
-					go time.AfterFunc(gracefulShutdownDelay, func() {
-						outgoingSignals <- s
-					})
-					break
+			// The lifecycle is shared by all the handlers of the manager (and can be drained by the
+			// application itself), only this handler's own count tells whether the signal is repeated
+			if seen == 1 {
+				m.handlers.lock.Lock()
+				if m.handlers.received == nil {
+					m.handlers.received = s
 				}
-				zlog.Info("Received termination signal twice, shutting down...", zap.String("signal", s.String()))
-				outgoingSignals <- s
+				m.handlers.lock.Unlock()
+
+				m.lifecycle.Drain(config.gracefulDelay)
 
+				config.logger.Info("Received termination signal... Ctrl+C multiple times to force kill", zap.String("signal", s.String()))
+				onDraining(s)
+				config.clock.AfterFunc(config.gracefulDelay, func() {
+					m.lifecycle.MarkStopping()
+					onShutdown(s, fmt.Sprintf("graceful shutdown delay of %s elapsed", config.gracefulDelay))
+				})
+				continue
 			}
+
+			config.logger.Info("Received termination signal twice, shutting down...", zap.String("signal", s.String()))
+			m.lifecycle.MarkStopping()
+			onShutdown(s, "repeated before the graceful shutdown delay elapsed")
 		}
 	}()
-
-	return outgoingSignals
 }
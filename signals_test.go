@@ -0,0 +1,180 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package derr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignalContext(t *testing.T) {
+	manager := NewShutdownManager(SignalNotify(syscall.SIGUSR2), SignalGracefulDelay(50*time.Millisecond))
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	ctx, draining := manager.SignalContext(parent)
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR2))
+
+	select {
+	case <-draining.Done():
+	case <-time.After(time.Second):
+		t.Fatal("draining context should have been canceled")
+	}
+
+	assert.NoError(t, ctx.Err())
+	assert.True(t, manager.IsShuttingDown())
+	assert.False(t, IsShuttingDown())
+
+	var signalErr *SignalError
+	require.True(t, errors.As(context.Cause(draining), &signalErr))
+	assert.Equal(t, syscall.SIGUSR2, signalErr.Signal)
+	assert.Equal(t, "received user defined signal 2 signal, draining", signalErr.Error())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context should have been canceled once the graceful delay elapsed")
+	}
+
+	assert.Equal(t, "received user defined signal 2 signal, graceful shutdown delay of 50ms elapsed", context.Cause(ctx).Error())
+	assert.Equal(t, 128+int(syscall.SIGUSR2), ExitCode(context.Cause(ctx)))
+	assert.Equal(t, syscall.SIGUSR2, manager.receivedSignal())
+	assert.Equal(t, LifecycleStopping, manager.Lifecycle().State())
+}
+
+func TestSignalContext_MultipleHandlers(t *testing.T) {
+	manager := NewShutdownManager(SignalNotify(), SignalGracefulDelay(50*time.Millisecond))
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	// Drained by the application before any signal, the handlers must still honor the graceful delay
+	manager.Lifecycle().Drain(time.Hour)
+
+	first, firstDraining := manager.SignalContext(parent)
+	second, secondDraining := manager.SignalContext(parent)
+	require.True(t, manager.RequestShutdown())
+
+	for _, draining := range []context.Context{firstDraining, secondDraining} {
+		select {
+		case <-draining.Done():
+		case <-time.After(time.Second):
+			t.Fatal("draining context should have been canceled")
+		}
+	}
+
+	assert.NoError(t, first.Err())
+	assert.NoError(t, second.Err())
+
+	for _, ctx := range []context.Context{first, second} {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("context should have been canceled once the graceful delay elapsed")
+		}
+
+		assert.Equal(t, "received terminated signal, graceful shutdown delay of 50ms elapsed", context.Cause(ctx).Error())
+	}
+}
+
+func TestSignalContext_Repeated(t *testing.T) {
+	manager := NewShutdownManager(SignalNotify(syscall.SIGUSR2), SignalGracefulDelay(time.Hour))
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	ctx, draining := manager.SignalContext(parent)
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR2))
+	<-draining.Done()
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR2))
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context should have been canceled by the second signal")
+	}
+
+	assert.Equal(t, "received user defined signal 2 signal, repeated before the graceful shutdown delay elapsed", context.Cause(ctx).Error())
+}
+
+func TestSignalContext_ParentCanceled(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, draining := SignalContext(parent, SignalNotify(syscall.SIGUSR2))
+
+	cancelParent()
+	<-ctx.Done()
+	<-draining.Done()
+
+	assert.Equal(t, context.Canceled, context.Cause(ctx))
+	assert.False(t, IsShuttingDown())
+}
+
+func TestShutdownManager_ForceKill(t *testing.T) {
+	exitCodes := make(chan int, 1)
+	manager := NewShutdownManager(SignalNotify(syscall.SIGUSR2), SignalGracefulDelay(time.Hour), SignalForceKillCount(1), SignalExitFunc(func(code int) {
+		exitCodes <- code
+	}))
+
+	signals := manager.SetupSignalHandler()
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR2))
+	require.Eventually(t, manager.IsShuttingDown, time.Second, time.Millisecond)
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR2))
+	select {
+	case code := <-exitCodes:
+		assert.Equal(t, 1, code)
+	case <-signals:
+		t.Fatal("the process should have been killed instead of shutting down")
+	case <-time.After(time.Second):
+		t.Fatal("the process should have been killed")
+	}
+}
+
+func TestShutdownManager_Diagnostics(t *testing.T) {
+	directory := t.TempDir()
+	manager := NewShutdownManager(SignalNotify(syscall.SIGUSR2), SignalDiagnostics(syscall.SIGUSR1, directory))
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	ctx, draining := manager.SignalContext(parent)
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+
+	require.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(directory, "diagnostics-*.txt"))
+		if len(files) != 1 {
+			return false
+		}
+
+		content, _ := os.ReadFile(files[0])
+		return bytes.Contains(content, []byte("=== Goroutines"))
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, ctx.Err())
+	assert.NoError(t, draining.Err())
+	assert.False(t, manager.IsShuttingDown())
+}
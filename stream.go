@@ -0,0 +1,144 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// WriteSSEError writes the receiver error as a Server-Sent Events `error` event on a stream
+// for which the headers have already been sent. The event's `data` is the same JSON as
+// the one written by [WriteError]. Like [WriteError], the error is logged at the right
+// level based on the actual status code.
+//
+// The `w` is flushed if it implements `http.Flusher`.
+func WriteSSEError(ctx context.Context, w http.ResponseWriter, message string, err error) {
+	response, zlogger := logErrorResponse(ctx, message, err)
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		logWriteError(zlogger, "unable to serialize error response", err)
+		return
+	}
+
+	_, err = w.Write([]byte("event: error\ndata: " + string(data) + "\n\n"))
+	if err != nil {
+		logWriteError(zlogger, "unable to write error event", err)
+		return
+	}
+
+	flush(w)
+}
+
+// WriteNDJSONError writes the receiver error as a single newline delimited JSON line
+// on a stream for which the headers have already been sent. The line is the JSON written
+// by [WriteError] enveloped in an `error` field, `{"error":{"code":...}}`, so clients
+// can tell it apart from regular data lines. Like [WriteError], the error is logged at
+// the right level based on the actual status code.
+//
+// The `w` is flushed if it implements `http.Flusher`.
+func WriteNDJSONError(ctx context.Context, w http.ResponseWriter, message string, err error) {
+	response, zlogger := logErrorResponse(ctx, message, err)
+
+	err = json.NewEncoder(w).Encode(struct {
+		Error *ErrorResponse `json:"error"`
+	}{response})
+	if err != nil {
+		logWriteError(zlogger, "unable to write error line", err)
+		return
+	}
+
+	flush(w)
+}
+
+// WebSocket close codes, see https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1 and
+// https://www.iana.org/assignments/websocket/websocket.xhtml#close-code-number
+const (
+	WebSocketCloseGoingAway       = 1001
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseInternalError   = 1011
+	WebSocketCloseTryAgainLater   = 1013
+)
+
+// The close frame payload is limited to 125 bytes, 2 of them being used by the code.
+const maxWebSocketCloseReasonLength = 123
+
+// WebSocketCloseError turns the receiver error into the close code and reason that should
+// be sent in a WebSocket close frame, leaving the actual write to the WebSocket library
+// in use. Like [WriteError], the error is logged at the right level based on the actual
+// status code.
+//
+// The reason is the JSON written by [WriteError]. Since a close reason is limited to 123
+// bytes, the `details` and then the `message` fields are dropped if the reason would be
+// too long, the `code` and `trace_id` fields being the ones kept in priority. If even those
+// don't fit, the reason is the `code` alone, not JSON encoded, truncated to 123 bytes.
+//
+// The status code is mapped to close code `1001` (Going Away) for [ShuttingDownError],
+// `1013` (Try Again Later) for other `503`, `1011` (Internal Error) for other `5XX`
+// and `1008` (Policy Violation) for `4XX`.
+func WebSocketCloseError(ctx context.Context, message string, err error) (code int, reason string) {
+	response, zlogger := logErrorResponse(ctx, message, err)
+
+	reduced := *response
+	for _, reduce := range []func(){func() {}, func() { reduced.Details = nil }, func() { reduced.Message = "" }} {
+		reduce()
+
+		data, err := json.Marshal(reduced)
+		if err != nil {
+			logWriteError(zlogger, "unable to serialize error response", err)
+			break
+		}
+
+		if len(data) <= maxWebSocketCloseReasonLength {
+			reason = string(data)
+			break
+		}
+	}
+
+	if reason == "" {
+		reason = string(response.Code)
+		if len(reason) > maxWebSocketCloseReasonLength {
+			reason = strings.ToValidUTF8(reason[:maxWebSocketCloseReasonLength], "")
+		}
+
+		zlogger.Debug("error response too long for a WebSocket close reason, sending its code only", zap.String("code", string(response.Code)))
+	}
+
+	return webSocketCloseCode(response), reason
+}
+
+func webSocketCloseCode(response *ErrorResponse) int {
+	switch {
+	case response.Code == shuttingDownErrorCode:
+		return WebSocketCloseGoingAway
+	case response.Status == http.StatusServiceUnavailable:
+		return WebSocketCloseTryAgainLater
+	case response.Status >= 500:
+		return WebSocketCloseInternalError
+	default:
+		return WebSocketClosePolicyViolation
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
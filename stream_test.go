@@ -0,0 +1,93 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/logging"
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+)
+
+func TestWriteSSEError(t *testing.T) {
+	ctx, traceID := testStreamContext()
+	recorder := httptest.NewRecorder()
+	recorder.WriteHeader(200)
+
+	WriteSSEError(ctx, recorder, "stream failed", InvalidJSONError(ctx, errors.New("bad")))
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, fmt.Sprintf(`event: error
+data: {"code":"invalid_json_error","trace_id":"%s","message":"The request is not a valid json.","details":{"errors":{"source":"bad"}}}
+
+`, traceID), recorder.Body.String())
+}
+
+func TestWriteNDJSONError(t *testing.T) {
+	ctx, traceID := testStreamContext()
+	recorder := httptest.NewRecorder()
+	recorder.WriteHeader(200)
+
+	WriteNDJSONError(ctx, recorder, "stream failed", errors.New("test"))
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, fmt.Sprintf(`{"error":{"code":"unexpected_error","trace_id":"%s","message":"An unexpected error occurred."}}`+"\n", traceID), recorder.Body.String())
+}
+
+func TestWebSocketCloseError(t *testing.T) {
+	ctx, traceID := testStreamContext()
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedCode   int
+		expectedReason string
+	}{
+		{"unexpected", errors.New("test"), 1011, `{"code":"unexpected_error","trace_id":"%s","message":"An unexpected error occurred."}`},
+		{"shutting down", ShuttingDownError(ctx), 1001, `{"code":"shutting_down_error","trace_id":"%s","message":"The service is shutting down."}`},
+		{"unavailable", HTTPServiceUnavailableError(ctx, nil, C("test_error"), "Test."), 1013, `{"code":"test_error","trace_id":"%s","message":"Test."}`},
+		{"bad request", HTTPBadRequestError(ctx, nil, C("test_error"), "Test.", "key", "value"), 1008, `{"code":"test_error","trace_id":"%s","message":"Test.","details":{"key":"value"}}`},
+		{"bad request too long", HTTPBadRequestError(ctx, nil, C("test_error"), "Test.", "key", strings.Repeat("a", 100)), 1008, `{"code":"test_error","trace_id":"%s","message":"Test."}`},
+		{"bad request way too long", HTTPBadRequestError(ctx, nil, C("test_error"), strings.Repeat("a", 100)), 1008, `{"code":"test_error","trace_id":"%s","message":""}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, reason := WebSocketCloseError(ctx, "stream failed", test.err)
+
+			assert.Equal(t, test.expectedCode, code)
+			assert.Equal(t, fmt.Sprintf(test.expectedReason, traceID), reason)
+			assert.LessOrEqual(t, len(reason), 123)
+		})
+	}
+
+	_, reason := WebSocketCloseError(ctx, "stream failed", HTTPBadRequestError(ctx, nil, C(strings.Repeat("a", 150)), "Test."))
+	assert.Equal(t, strings.Repeat("a", 123), reason, "the code should be sent alone when nothing else fits")
+}
+
+func testStreamContext() (context.Context, trace.TraceID) {
+	traceID := fixedTraceID("00000000000000000000000000000001")
+	ctx, _ := trace.StartSpanWithRemoteParent(context.Background(), "test", trace.SpanContext{TraceID: traceID})
+
+	return logging.WithLogger(ctx, zap.NewNop()), traceID
+}
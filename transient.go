@@ -0,0 +1,122 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type transience int
+
+const (
+	transienceUnknown transience = iota
+	transienceTransient
+	transiencePermanent
+)
+
+// IsTransient walks the error(s) stack (causes chain) of `err` and returns whether the first
+// classifiable error found in it is transient, that is likely to succeed if tried again. The
+// transient errors are:
+//   - a [RetryableError];
+//   - a gRPC status `Unavailable`, `ResourceExhausted`, `Aborted` or `DeadlineExceeded`;
+//   - an [ErrorResponse] with a `429`, `502`, `503` or `504` status;
+//   - a network timeout, a refused or reset connection (see [ClassifyNetworkError]);
+//   - `context.DeadlineExceeded` and `io.ErrUnexpectedEOF`.
+//
+// A [FatalError], a gRPC status like `InvalidArgument`, `NotFound` or `PermissionDenied` and an
+// [ErrorResponse] with another `4xx` status are permanent. Any other error is unclassified,
+// see [TransientClassifier] for how those are retried.
+func IsTransient(err error) bool {
+	return classifyTransience(err) == transienceTransient
+}
+
+// TransientClassifier is the default [RetryClassifier] of [RetryContext] and [CircuitBreaker]. It
+// retries the errors [IsTransient] reports as transient as well as the unclassified ones, only
+// the errors known to be permanent being returned right away. Use
+// `RetryStrict(RetryClassifierFunc(IsTransient))` to retry only the known transient errors.
+var TransientClassifier RetryClassifier = RetryClassifierFunc(func(err error) bool {
+	return classifyTransience(err) != transiencePermanent
+})
+
+func classifyTransience(err error) (out transience) {
+	Walk(err, func(candidateErr error) (bool, error) {
+		out = candidateTransience(candidateErr)
+		return out == transienceUnknown, nil
+	})
+
+	if out != transienceUnknown {
+		return out
+	}
+
+	switch ClassifyNetworkError(err) {
+	case NetworkErrorTimeout, NetworkErrorServerTimeout, NetworkErrorRefused, NetworkErrorClientGone:
+		return transienceTransient
+	}
+
+	return transienceUnknown
+}
+
+func candidateTransience(err error) transience {
+	switch v := err.(type) {
+	case nil:
+		return transienceUnknown
+	case *RetryableError:
+		return transienceTransient
+	case *FatalError:
+		return transiencePermanent
+	case *ErrorResponse:
+		if v.grpcStatus != nil {
+			// Received from a gRPC server, its code is more precise than the HTTP status it maps to
+			return grpcCodeTransience(v.grpcStatus.Code())
+		}
+
+		return httpStatusTransience(v.Status)
+	case interface{ GRPCStatus() *status.Status }:
+		return grpcCodeTransience(v.GRPCStatus().Code())
+	}
+
+	if err == context.DeadlineExceeded || err == io.ErrUnexpectedEOF {
+		return transienceTransient
+	}
+
+	return transienceUnknown
+}
+
+func httpStatusTransience(code int) transience {
+	switch {
+	case code == http.StatusTooManyRequests, code == http.StatusBadGateway, code == http.StatusServiceUnavailable, code == http.StatusGatewayTimeout:
+		return transienceTransient
+	case code >= 400 && code < 500:
+		return transiencePermanent
+	}
+
+	return transienceUnknown
+}
+
+func grpcCodeTransience(code codes.Code) transience {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return transienceTransient
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied, codes.Unauthenticated, codes.AlreadyExists, codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented:
+		return transiencePermanent
+	}
+
+	return transienceUnknown
+}
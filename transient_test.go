@@ -0,0 +1,109 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransient(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name              string
+		err               error
+		expectedTransient bool
+		expectedRetryable bool
+	}{
+		{"nil", nil, false, true},
+		{"plain", errors.New("test"), false, true},
+		{"retryable", fmt.Errorf("wrapped: %w", NewRetryableError(errors.New("test"))), true, true},
+		{"fatal", NewFatalError(Status(codes.Unavailable, "test")), false, false},
+		{"grpc unavailable", Wrap(Status(codes.Unavailable, "test"), "wrapped"), true, true},
+		{"grpc resource exhausted", Status(codes.ResourceExhausted, "test"), true, true},
+		{"grpc aborted", Status(codes.Aborted, "test"), true, true},
+		{"grpc deadline exceeded", Status(codes.DeadlineExceeded, "test"), true, true},
+		{"grpc invalid argument", Status(codes.InvalidArgument, "test"), false, false},
+		{"grpc not found", Status(codes.NotFound, "test"), false, false},
+		{"grpc permission denied", Status(codes.PermissionDenied, "test"), false, false},
+		{"grpc internal", Status(codes.Internal, "test"), false, true},
+		{"http 429", HTTPTooManyRequestsError(ctx, nil, C("test_error"), "Slow down."), true, true},
+		{"http 503", Wrap(HTTPServiceUnavailableError(ctx, nil, C("test_error"), "Unavailable."), "wrapped"), true, true},
+		{"http 404", HTTPNotFoundError(ctx, nil, C("test_error"), "Not found."), false, false},
+		{"http 500", HTTPInternalServerError(ctx, nil, C("test_error"), "Internal."), false, true},
+		{"net timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ETIMEDOUT)}, true, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true, true},
+		{"unexpected eof", fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), true, true},
+		{"context deadline", context.DeadlineExceeded, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectedTransient, IsTransient(test.err))
+			assert.Equal(t, test.expectedRetryable, TransientClassifier.IsRetryable(test.err))
+		})
+	}
+}
+
+func TestIsTransient_DefaultClassifier(t *testing.T) {
+	fast := RetryBackoff(BackoffConstant, time.Millisecond, 0)
+
+	var count int
+	err := RetryContext(context.Background(), 2, func(ctx context.Context) error {
+		count++
+		return Status(codes.InvalidArgument, "invalid")
+	}, fast)
+	assert.Equal(t, codes.InvalidArgument, ToGRPCStatus(context.Background(), err).Code())
+	assert.Equal(t, 1, count)
+
+	count = 0
+	RetryContext(context.Background(), 2, func(ctx context.Context) error {
+		count++
+		return Status(codes.InvalidArgument, "invalid")
+	}, fast, RetryClassifiedBy(RetryClassifierFunc(func(err error) bool { return true })))
+	assert.Equal(t, 3, count)
+
+	cb := NewCircuitBreaker("test", CircuitConsecutiveFailures(1))
+	cb.Execute(context.Background(), func(ctx context.Context) error { return Status(codes.NotFound, "not found") })
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	cb.Execute(context.Background(), func(ctx context.Context) error { return Status(codes.Unavailable, "unavailable") })
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestIsTransient_InterceptedStatus(t *testing.T) {
+	err := UnaryClientInterceptor()(context.Background(), "/test/Method", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Aborted, "conflict, try again")
+	})
+
+	response, ok := err.(*ErrorResponse)
+	assert.True(t, ok)
+	assert.Equal(t, 409, response.Status)
+	assert.True(t, TransientClassifier.IsRetryable(err), "the gRPC code should prevail over the HTTP status it maps to")
+}
@@ -18,6 +18,9 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
+
+	"google.golang.org/grpc/status"
 )
 
 type ErrorCode string
@@ -32,12 +35,30 @@ type ErrorResponse struct {
 	Message string                 `json:"message"`
 	Details map[string]interface{} `json:"details,omitempty"`
 	Causer  error                  `json:"-"`
+
+	// RetryDelay is the minimum delay the client should wait before retrying the request,
+	// zero if unknown. It is sent as an `errdetails.RetryInfo` detail over gRPC.
+	RetryDelay time.Duration `json:"-"`
+
+	// grpcStatus is the gRPC status this response was reconstructed from, if any,
+	// see [FromGRPCStatus].
+	grpcStatus *status.Status
 }
 
 func (e *ErrorResponse) Cause() error { return e.Causer }
 
 func (e *ErrorResponse) ResponseStatus() int { return e.Status }
 
+// GRPCStatus returns the gRPC status this response was reconstructed from if any, otherwise
+// converts the response to a gRPC status, see [ToGRPCStatus] for the details.
+func (e *ErrorResponse) GRPCStatus() *status.Status {
+	if e.grpcStatus != nil {
+		return e.grpcStatus
+	}
+
+	return convertErrorResponseToStatus(e)
+}
+
 func (e *ErrorResponse) Error() string {
 	index := 0
 	details := make([]string, len(e.Details))
@@ -24,7 +24,7 @@ import (
 
 // Wrap is a shortcut for `pkgErrors.Wrap` (where `pkgErrors` is `github.com/pkg/errors`)
 func Wrap(err error, message string) error {
-	if se, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+	if se, ok := err.(interface{ GRPCStatus() *status.Status }); ok && !isErrorResponse(err) {
 		sts := se.GRPCStatus().Proto()
 		newSts := &spb.Status{
 			Code:    sts.Code,
@@ -43,7 +43,7 @@ func Wrap(err error, message string) error {
 
 // Wrapf is a shortcut for `pkgErrors.Wrapf` (where `pkgErrors` is `github.com/pkg/errors`)
 func Wrapf(err error, format string, args ...interface{}) error {
-	if se, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+	if se, ok := err.(interface{ GRPCStatus() *status.Status }); ok && !isErrorResponse(err) {
 		sts := se.GRPCStatus().Proto()
 		newSts := &spb.Status{
 			Code:    sts.Code,